@@ -1,17 +1,24 @@
 import CreateEvent from "../Components/Responses/CreateEvent.svelte"
-import Help from "../Components/Responses/Help.svelte"
+import Output from "../Components/Responses/Output.svelte"
+import Text from "../Components/Responses/Text.svelte"
 import UnknownCommand from "../Components/Responses/UnknownCommand.svelte"
+import { Rpc } from "./Rpc"
 
+// Commands handled by the client, everything else is executed by the server's
+// shell, see `execute_remote`.
 const COMMANDS = {
     "clear": clear,
     "event.create": event_create,
-    "help": help,
 }
 
-function help(c: Context) {
-    c.Extra.Set("Commands", Object.keys(COMMANDS))
-    c.Send(Help)
-}
+// Current domain of the terminal session, changed by `setdomain`.
+let domain = "main"
+
+// Token of the question asked by the last command, answered by the next input.
+let confirmToken = null
+
+// Keeps `@N` rows and questions of this terminal apart from other clients.
+const session = crypto.randomUUID()
 
 function clear(c: Context) {
     c.Reset()
@@ -23,11 +30,39 @@ function event_create(c: Context) {
     c.Send(CreateEvent)
 }
 
+async function execute_remote(c: Context) {
+    let result = null
+    if (confirmToken != null) {
+        let answer = c.Prompt.toLowerCase()
+        if (answer != "y" && answer != "n") {
+            c.Extra.Set("Text", "TYPE ANSWER 'Y' OR 'N'")
+            c.Send(Text)
+            return
+        }
+        result = await Rpc("Shell/Confirm", {Token: confirmToken, Answer: answer == "y", Session: session})
+        confirmToken = null
+    } else {
+        result = await Rpc("Shell/Execute", {Input: c.Prompt, Domain: domain, Session: session})
+    }
+    if (result == undefined) {
+        c.Send(UnknownCommand)
+        return
+    }
+    if (result.Domain != "") {
+        domain = result.Domain
+    }
+    if (result.Confirm != null) {
+        confirmToken = result.Confirm.Token
+    }
+    c.Extra.Set("Output", result.Output)
+    c.Send(Output)
+}
+
 export function ExecuteCommand(c: Context): any {
     let fn = COMMANDS[c.Prompt]
-    if (fn == undefined) {
+    if (fn == undefined || confirmToken != null) {
         if (c.Prompt != "") {
-            c.Send(UnknownCommand)
+            execute_remote(c)
             return
         }
         c.Send(null)
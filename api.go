@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoints for the web client and the remote shell, see `remote.go`.
+
+type Rpc_Domain_Args struct {
+	Domain string
+}
+
+type Rpc_Create_Domain_Args struct {
+	Domain string
+	// Name of a template to take signatures from, empty creates an empty
+	// domain.
+	Template string
+}
+
+type Rpc_Save_Template_Args struct {
+	Name   string
+	Domain string
+}
+
+type Rpc_Domain_Target_Args struct {
+	Domain string
+	Target string
+	// Last event copied by fork, as `#SEQ` or time, rejected by copy.
+	As_Of string
+}
+
+type Rpc_Create_Signature_Args struct {
+	Domain    string
+	EventType string
+	Fields    map[string]string
+}
+
+type Rpc_Create_Event_Args struct {
+	Domain    string
+	EventType string
+	// Values of any JSON type, they are stored as strings.
+	Body map[string]any
+}
+
+type Rpc_Get_Events_Args struct {
+	Domain    string
+	EventType string
+	Limit     int
+	// Time range like `yesterday` or `2026-01..2026-03`, empty for any time.
+	Time string
+}
+
+type Rpc_Get_Event_Args struct {
+	Domain string
+	Seq    int
+}
+
+type Rpc_Wait_Events_Args struct {
+	Domain string
+	// Sequence of the last event the client has.
+	After       int
+	Timeout_Sec int
+}
+
+// RFC3339 with milliseconds, keeping the offset of the display timezone.
+const RPC_DATE_FORMAT = "2006-01-02T15:04:05.000Z07:00"
+
+// Event as returned to clients, with it's time formatted in the display
+// timezone, see `[time] timezone` of the config.
+type Rpc_Event struct {
+	*Event
+	Time string
+}
+
+func rpc_event(event *Event) *Rpc_Event {
+	return &Rpc_Event{Event: event, Time: bone.Date_Ms(event.Created_Ms, RPC_DATE_FORMAT)}
+}
+
+func rpc_events(evs []*Event) []*Rpc_Event {
+	r := make([]*Rpc_Event, 0, len(evs))
+	for _, event := range evs {
+		r = append(r, rpc_event(event))
+	}
+	return r
+}
+
+type Field_Spec struct {
+	Type string
+}
+
+func bind_args(c *gin.Context, args any) bool {
+	er := c.ShouldBindJSON(args)
+	if er != nil {
+		rpc.Fail(c, ERR_INVALID_ARGUMENTS.With("reason", er))
+		return false
+	}
+	return true
+}
+
+func require_domain(c *gin.Context, domain string) bool {
+	_, ok := signatures[domain]
+	if !ok {
+		rpc.Fail(c, ERR_DOMAIN_NOT_FOUND.With("domain", domain))
+		return false
+	}
+	return true
+}
+
+func rpc_get_domains(c *gin.Context) {
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	rpc.Ok(c, sorted_domains())
+}
+
+func rpc_get_domain_infos(c *gin.Context) {
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	rpc.Ok(c, domain_infos())
+}
+
+func rpc_create_domain(c *gin.Context) {
+	var args Rpc_Create_Domain_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	var er error
+	if args.Template != "" {
+		er = create_domain_from(args.Domain, args.Template)
+	} else {
+		er = create_domain(args.Domain)
+	}
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, args.Domain)
+}
+
+func rpc_rename_domain(c *gin.Context) {
+	var args Rpc_Domain_Target_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	er := rename_domain(args.Domain, args.Target)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, args.Target)
+}
+
+func rpc_delete_domain(c *gin.Context) {
+	var args Rpc_Domain_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	er := delete_domain(args.Domain)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, args.Domain)
+}
+
+// Copies a domain with it's whole history.
+func rpc_copy_domain(c *gin.Context) {
+	var args Rpc_Domain_Target_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	if args.As_Of != "" {
+		rpc.Fail(c, ERR_INVALID_ARGUMENTS.With("reason", "copy takes the whole history, use ForkDomain with As_Of"))
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	er := fork_domain(args.Domain, args.Target, "")
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, args.Target)
+}
+
+// Copies history of a domain up to `As_Of`.
+func rpc_fork_domain(c *gin.Context) {
+	var args Rpc_Domain_Target_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	if args.As_Of == "" {
+		rpc.Fail(c, ERR_INVALID_ARGUMENTS.With("reason", "fork requires As_Of, use CopyDomain for the whole history"))
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	er := fork_domain(args.Domain, args.Target, args.As_Of)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, args.Target)
+}
+
+func rpc_get_templates(c *gin.Context) {
+	rpc.Ok(c, list_templates())
+}
+
+func rpc_save_template(c *gin.Context) {
+	var args Rpc_Save_Template_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	t, er := save_template(args.Name, args.Domain)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, t)
+}
+
+func rpc_get_signatures(c *gin.Context) {
+	var args Rpc_Domain_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if !require_domain(c, args.Domain) {
+		return
+	}
+	rpc.Ok(c, signature_infos(args.Domain))
+}
+
+// Returns fields of every signature by type names, in shape expected by the
+// web client's event form.
+func rpc_get_specs(c *gin.Context) {
+	var args Rpc_Domain_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if !require_domain(c, args.Domain) {
+		return
+	}
+	specs := map[string]map[string]*Field_Spec{}
+	for _, signature := range signatures[args.Domain] {
+		fields := map[string]*Field_Spec{}
+		for key, value := range signature.Fields {
+			fields[key] = &Field_Spec{Type: value}
+		}
+		specs[signature.Type_Name] = fields
+	}
+	rpc.Ok(c, specs)
+}
+
+func rpc_create_signature(c *gin.Context) {
+	var args Rpc_Create_Signature_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	if args.Fields == nil {
+		args.Fields = map[string]string{}
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	signature, er := add_signature(args.Domain, args.EventType, args.Fields)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, signature)
+}
+
+func rpc_create_event(c *gin.Context) {
+	var args Rpc_Create_Event_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	fields := map[string]string{}
+	for key, value := range args.Body {
+		fields[key] = fmt.Sprint(value)
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	event, er := add_event(args.Domain, args.EventType, fields)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, rpc_event(event))
+}
+
+func rpc_get_events(c *gin.Context) {
+	var args Rpc_Get_Events_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if !require_domain(c, args.Domain) {
+		return
+	}
+	evs, er := select_events(args.Domain, args.Limit, args.EventType, args.Time)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, rpc_events(evs))
+}
+
+func rpc_get_event(c *gin.Context) {
+	var args Rpc_Get_Event_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if !require_domain(c, args.Domain) {
+		return
+	}
+	event, er := find_event(args.Domain, args.Seq)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	if event == nil {
+		rpc.Fail(c, ERR_EVENT_NOT_FOUND.With("seq", args.Seq))
+		return
+	}
+	rpc.Ok(c, rpc_event(event))
+}
+
+// Returns events after the given sequence, waiting for a new one if there
+// are none yet. Empty list is returned on timeout, so clients poll again.
+func rpc_wait_events(c *gin.Context) {
+	var args Rpc_Wait_Events_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	if args.Timeout_Sec <= 0 || args.Timeout_Sec > 60 {
+		args.Timeout_Sec = 20
+	}
+
+	// Listen before reading the state, so no event is missed in between
+	id, ch := listen()
+	defer unlisten(id)
+
+	state_lock.Lock()
+	if !require_domain(c, args.Domain) {
+		state_lock.Unlock()
+		return
+	}
+	evs, er := events_range(args.Domain, args.After+1, 0)
+	state_lock.Unlock()
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+
+	timer := time.NewTimer(time.Duration(args.Timeout_Sec) * time.Second)
+	defer timer.Stop()
+	for len(evs) == 0 {
+		select {
+		case notice := <-ch:
+			if notice.Domain == args.Domain && notice.Event.Seq > args.After {
+				evs = append(evs, notice.Event)
+			}
+		case <-timer.C:
+			rpc.Ok(c, rpc_events(evs))
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+	rpc.Ok(c, rpc_events(evs))
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"seva/lib/shell"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signed statement that a domain's chain had the hash at the sequence.
+type Checkpoint struct {
+	Seq         int    `json:"seq"`
+	Hash        string `json:"hash"`
+	Created_Sec int    `json:"created_sec"`
+	// Hex ed25519 signature of the domain, sequence and hash.
+	Signature string `json:"signature"`
+}
+
+type Chain_Report struct {
+	Ok bool
+	// Amount of events checked before the first break.
+	Checked int
+	// Sequence of the first broken event, zero if the chain is intact.
+	Seq    int
+	Reason string
+}
+
+// Everything of an event except it's hash, in a stable form. Events stored
+// before milliseconds were introduced are hashed by their seconds, so their
+// chains verify after the migration.
+func event_canonical(event *Event) []byte {
+	var data []byte
+	var er error
+	if event.Created_Sec != 0 {
+		data, er = json.Marshal(struct {
+			Seq         int               `json:"seq"`
+			Created_Sec int               `json:"created_sec"`
+			Type        int               `json:"type"`
+			Fields      map[string]string `json:"fields"`
+		}{event.Seq, event.Created_Sec, event.Type, event.Fields})
+	} else {
+		data, er = json.Marshal(struct {
+			Seq        int               `json:"seq"`
+			Created_Ms int64             `json:"created_ms"`
+			Type       int               `json:"type"`
+			Fields     map[string]string `json:"fields"`
+		}{event.Seq, event.Created_Ms, event.Type, event.Fields})
+	}
+	bone.Assert(er == nil, "Cannot marshal canonical event: %s", er)
+	return data
+}
+
+func chain_hash(prev_hash string, event *Event) string {
+	h := sha256.New()
+	h.Write([]byte(prev_hash))
+	h.Write([]byte("\n"))
+	h.Write(event_canonical(event))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash preceding the first stored event of a domain.
+func chain_base(domain string) string {
+	index, ok := segment_indexes[domain]
+	if !ok {
+		return ""
+	}
+	return index.Base_Hash
+}
+
+// Returns sequence and hash of the last event of a domain. Sequence is zero
+// if no events are stored.
+func chain_head(domain string) (int, string) {
+	evs := events[domain]
+	if len(evs) > 0 {
+		return evs[len(evs)-1].Seq, evs[len(evs)-1].Hash
+	}
+	segment := segment_last(domain)
+	if segment != nil {
+		return segment.Last_Seq, segment.Last_Hash
+	}
+	return 0, chain_base(domain)
+}
+
+// Sets hash of an event to be appended to a domain.
+func chain_link(domain string, event *Event) {
+	_, prev_hash := chain_head(domain)
+	event.Hash = chain_hash(prev_hash, event)
+}
+
+// Chains events of a domain stored before hashes were introduced, it's whole
+// history is expected in memory. Domains having at least one hashed event are
+// left as is, so removed hashes are reported by verification instead of being
+// silently restored.
+func chain_init(domain string) bool {
+	for _, event := range events[domain] {
+		if event.Hash != "" {
+			return false
+		}
+	}
+	if len(events[domain]) == 0 {
+		return false
+	}
+	prev_hash := chain_base(domain)
+	for _, event := range events[domain] {
+		event.Hash = chain_hash(prev_hash, event)
+		prev_hash = event.Hash
+	}
+	return true
+}
+
+// Walks the stored chain of a domain and reports the first break.
+func chain_verify(domain string) *Chain_Report {
+	report := &Chain_Report{}
+	evs, er := read_events_range(domain, 1, 0)
+	if er != nil {
+		report.Reason = "cannot read events"
+		return report
+	}
+
+	hashes := map[int]string{}
+	prev_hash := chain_base(domain)
+	prev_seq := 0
+	for _, event := range evs {
+		if prev_seq != 0 && event.Seq != prev_seq+1 {
+			report.Seq = event.Seq
+			report.Reason = fmt.Sprintf("sequence gap after #%d", prev_seq)
+			return report
+		}
+		if event.Hash == "" {
+			report.Seq = event.Seq
+			report.Reason = "missing hash"
+			return report
+		}
+		if chain_hash(prev_hash, event) != event.Hash {
+			report.Seq = event.Seq
+			report.Reason = "hash mismatch"
+			return report
+		}
+		hashes[event.Seq] = event.Hash
+		prev_hash = event.Hash
+		prev_seq = event.Seq
+		report.Checked++
+	}
+
+	checkpoints, er := chain_read_checkpoints(domain)
+	if er != nil {
+		report.Reason = "cannot read checkpoints"
+		return report
+	}
+	// Checkpoints of events removed by retention are not checked
+	first_seq := 1
+	index, ok := segment_indexes[domain]
+	if ok && len(index.Segments) > 0 {
+		first_seq = index.Segments[0].First_Seq
+	}
+	public_key, key_er := chain_public_key()
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Seq < first_seq {
+			continue
+		}
+		hash, ok := hashes[checkpoint.Seq]
+		if !ok {
+			report.Seq = checkpoint.Seq
+			report.Reason = "checkpointed event is missing"
+			return report
+		}
+		if key_er != nil {
+			report.Seq = checkpoint.Seq
+			report.Reason = "no checkpoint key"
+			return report
+		}
+		if hash != checkpoint.Hash {
+			report.Seq = checkpoint.Seq
+			report.Reason = "hash differs from checkpoint"
+			return report
+		}
+		signature, er := hex.DecodeString(checkpoint.Signature)
+		if er != nil || !ed25519.Verify(public_key, checkpoint_message(domain, checkpoint), signature) {
+			report.Seq = checkpoint.Seq
+			report.Reason = "invalid checkpoint signature"
+			return report
+		}
+	}
+
+	// Removed newest events leave no broken link, so the chain has to reach
+	// the last event known to the index
+	last_seq := 0
+	for i := 0; ok && i < len(index.Segments); i++ {
+		segment := index.Segments[i]
+		if segment.Last_Seq >= segment.First_Seq {
+			last_seq = segment.Last_Seq
+		}
+	}
+	if prev_seq < last_seq {
+		report.Seq = prev_seq + 1
+		report.Reason = "chain is truncated"
+		return report
+	}
+
+	report.Ok = true
+	return report
+}
+
+func chain_key_path() string {
+	return bone.Userdir("keys", "checkpoint.key")
+}
+
+// Returns private key for checkpoints signing, generating it on the first
+// use.
+func chain_private_key() (ed25519.PrivateKey, error) {
+	path := chain_key_path()
+	data, er := os.ReadFile(path)
+	if er == nil {
+		seed, er := hex.DecodeString(string(data))
+		if er != nil || len(seed) != ed25519.SeedSize {
+			return nil, ERR_CHECKPOINT_KEY.With("path", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(er) {
+		return nil, ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+
+	_, private_key, er := ed25519.GenerateKey(rand.Reader)
+	if er != nil {
+		return nil, ERR_ENCODE.With("what", "checkpoint key").Wrap(er)
+	}
+	bone.Mkdir(filepath.Dir(path))
+	er = os.WriteFile(path, []byte(hex.EncodeToString(private_key.Seed())), 0600)
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "write", "path", path).Wrap(er)
+	}
+	return private_key, nil
+}
+
+// Returns public key of the existing checkpoint key, unlike
+// `chain_private_key` it never generates one.
+func chain_public_key() (ed25519.PublicKey, error) {
+	path := chain_key_path()
+	data, er := os.ReadFile(path)
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	seed, er := hex.DecodeString(string(data))
+	if er != nil || len(seed) != ed25519.SeedSize {
+		return nil, ERR_CHECKPOINT_KEY.With("path", path)
+	}
+	return ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), nil
+}
+
+func checkpoint_message(domain string, checkpoint *Checkpoint) []byte {
+	return []byte(fmt.Sprintf("%s\n%d\n%s", domain, checkpoint.Seq, checkpoint.Hash))
+}
+
+func chain_checkpoints_path(domain string) string {
+	return bone.Userdir("checkpoints", domain+".json")
+}
+
+// A domain without checkpoints file has no checkpoints.
+func chain_read_checkpoints(domain string) ([]*Checkpoint, error) {
+	checkpoints := []*Checkpoint{}
+	path := chain_checkpoints_path(domain)
+	data, er := os.ReadFile(path)
+	if os.IsNotExist(er) {
+		return checkpoints, nil
+	}
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	er = json.Unmarshal(data, &checkpoints)
+	if er != nil {
+		return nil, ERR_CORRUPT.With("path", path).Wrap(er)
+	}
+	return checkpoints, nil
+}
+
+// Signs the current head of a domain's chain.
+func chain_checkpoint(domain string) error {
+	seq, hash := chain_head(domain)
+	if seq == 0 {
+		return nil
+	}
+	private_key, er := chain_private_key()
+	if er != nil {
+		return er
+	}
+	checkpoint := &Checkpoint{
+		Seq:         seq,
+		Hash:        hash,
+		Created_Sec: int(bone.Utc_Sec()),
+	}
+	checkpoint.Signature = hex.EncodeToString(ed25519.Sign(private_key, checkpoint_message(domain, checkpoint)))
+
+	checkpoints, er := chain_read_checkpoints(domain)
+	if er != nil {
+		return er
+	}
+	checkpoints = append(checkpoints, checkpoint)
+	data, er := json.MarshalIndent(checkpoints, "", "\t")
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("checkpoints of domain '%s'", domain)).Wrap(er)
+	}
+	bone.Mkdir(bone.Userdir("checkpoints"))
+	return write_file_atomic(chain_checkpoints_path(domain), data)
+}
+
+// Moves checkpoints of a renamed domain, signing them for the new name. Ones
+// which do not verify under the old name are moved as they are, so they are
+// still reported as broken.
+func chain_resign(old string, new string) error {
+	checkpoints, er := chain_read_checkpoints(old)
+	if er != nil || len(checkpoints) == 0 {
+		return er
+	}
+	private_key, er := chain_private_key()
+	if er != nil {
+		return er
+	}
+	public_key := private_key.Public().(ed25519.PublicKey)
+	for _, checkpoint := range checkpoints {
+		signature, er := hex.DecodeString(checkpoint.Signature)
+		if er != nil || !ed25519.Verify(public_key, checkpoint_message(old, checkpoint), signature) {
+			continue
+		}
+		checkpoint.Signature = hex.EncodeToString(ed25519.Sign(private_key, checkpoint_message(new, checkpoint)))
+	}
+
+	data, er := json.MarshalIndent(checkpoints, "", "\t")
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("checkpoints of domain '%s'", new)).Wrap(er)
+	}
+	er = write_file_atomic(chain_checkpoints_path(new), data)
+	if er != nil {
+		return er
+	}
+	path := chain_checkpoints_path(old)
+	er = os.Remove(path)
+	if er != nil {
+		return ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+	}
+	return nil
+}
+
+// Signs a checkpoint each time the configured amount of events is appended.
+// Disabled by default.
+func chain_checkpoint_periodic(domain string) {
+	interval := bone.Config.Int("chain", "checkpoint_interval")
+	if interval <= 0 {
+		return
+	}
+	seq := next_seq(domain) - 1
+	if seq == 0 || seq%interval != 0 {
+		return
+	}
+	er := chain_checkpoint(domain)
+	if er != nil {
+		bone.Log_Error("%s", er)
+	}
+}
+
+func shell_verify(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	if c.Arg_Bool("-checkpoint", false) {
+		er := chain_checkpoint(domain)
+		if er != nil {
+			return c.Fail(er)
+		}
+	}
+	return render_report(c, domain, chain_verify(domain))
+}
+
+func render_report(c *shell.Command_Context, domain string, report *Chain_Report) int {
+	if !report.Ok {
+		c.Error("Chain of domain '%s' is broken at #%d: %s", domain, report.Seq, report.Reason)
+		return shell.ERROR
+	}
+	c.Message_Plural(report.Checked, "Chain of domain '%s' is intact, %d events checked", domain, report.Checked)
+	return shell.OK
+}
+
+type Rpc_Verify_Args struct {
+	Domain string
+}
+
+func rpc_verify(c *gin.Context) {
+	var args Rpc_Verify_Args
+	er := c.ShouldBindJSON(&args)
+	if er != nil {
+		rpc.Fail(c, ERR_INVALID_ARGUMENTS.With("reason", er))
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if _, ok := events[args.Domain]; !ok {
+		rpc.Fail(c, ERR_DOMAIN_NOT_FOUND.With("domain", args.Domain))
+		return
+	}
+	rpc.Ok(c, chain_verify(args.Domain))
+}
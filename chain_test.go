@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"seva/lib/bone"
+	"testing"
+)
+
+// Creates a domain of three chained events, checkpointed at the last one.
+func chain_test_domain() {
+	test_notes("chain_test", "a", "b", "c")
+	bone.Assert(chain_checkpoint("chain_test") == nil)
+}
+
+// Writes events to the only segment of the domain, as if the file was
+// edited.
+func chain_test_store(evs []*Event) {
+	segment := segment_indexes["chain_test"].Segments[0]
+	bone.Assert(segment_write("chain_test", segment, evs) == nil)
+}
+
+func Test_chain_verify_ok(t *testing.T) {
+	chain_test_domain()
+	report := chain_verify("chain_test")
+	bone.Assert(report.Ok && report.Checked == 3 && report.Seq == 0)
+}
+
+func Test_chain_verify_tampering(t *testing.T) {
+	chain_test_domain()
+	evs := events["chain_test"]
+	tampered := *evs[1]
+	tampered.Fields = map[string]string{"text": "x"}
+	chain_test_store([]*Event{evs[0], &tampered, evs[2]})
+	report := chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Seq == 2 && report.Reason == "hash mismatch")
+
+	chain_test_store([]*Event{evs[0], evs[2]})
+	report = chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Seq == 3 && report.Checked == 1)
+}
+
+func Test_chain_verify_truncation(t *testing.T) {
+	chain_test_domain()
+	chain_test_store(events["chain_test"][:2])
+	report := chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Seq == 3 && report.Reason == "checkpointed event is missing")
+}
+
+func Test_chain_verify_truncation_unchecked(t *testing.T) {
+	chain_test_domain()
+	bone.Assert(os.Remove(chain_checkpoints_path("chain_test")) == nil)
+	evs := events["chain_test"]
+	chain_test_store(evs[:2])
+	report := chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Seq == 3 && report.Checked == 2 && report.Reason == "chain is truncated")
+
+	chain_test_store([]*Event{})
+	report = chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Seq == 1 && report.Checked == 0)
+
+	chain_test_store(evs)
+	bone.Assert(chain_verify("chain_test").Ok)
+}
+
+func Test_chain_verify_key(t *testing.T) {
+	chain_test_domain()
+	data, er := os.ReadFile(chain_key_path())
+	bone.Assert(er == nil)
+	bone.Assert(os.Remove(chain_key_path()) == nil)
+	defer os.WriteFile(chain_key_path(), data, 0600)
+
+	// Verification does not create a key, which would not match the
+	// checkpoints anyway
+	report := chain_verify("chain_test")
+	bone.Assert(!report.Ok && report.Reason == "no checkpoint key")
+	_, er = os.Stat(chain_key_path())
+	bone.Assert(os.IsNotExist(er))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+var config_specs = []*bone.Config_Spec{
+	{Section: "main", Key: "domain", Type: bone.CONFIG_STRING, Default: "main", Description: "Current domain of the shell."},
+	{Section: "remote", Key: "token", Type: bone.CONFIG_STRING, Description: "Token sent to the server by `-remote` shell."},
+	{Section: "server", Key: "token", Type: bone.CONFIG_STRING, Description: "Token required from clients, empty disables the check and the Shell endpoints."},
+	{Section: "server", Key: "cors_origins", Type: bone.CONFIG_STRING, Default: "*", Description: "Comma separated origins allowed to call the server, `*` allows any."},
+	{Section: "chain", Key: "checkpoint_interval", Type: bone.CONFIG_INT, Default: "0", Description: "Events between signed checkpoints, 0 disables them."},
+	{Section: "segment", Key: "max_bytes", Type: bone.CONFIG_INT, Default: "4194304", Description: "Size after which a segment is sealed."},
+	{Section: "segment", Key: "max_hours", Type: bone.CONFIG_INT, Default: "0", Description: "Age after which a segment is sealed, 0 disables it."},
+	{Section: "segment", Key: "compress", Type: bone.CONFIG_BOOL, Default: "false", Description: "Compresses sealed segments."},
+	{Section: "snapshot", Key: "interval", Type: bone.CONFIG_INT, Default: "1000", Description: "Events between automatic snapshots, 0 disables them."},
+	{Section: "snapshot", Key: "keep", Type: bone.CONFIG_INT, Default: "3", Description: "Amount of snapshots kept per domain."},
+	{Section: "retention", Key: "interval_sec", Type: bone.CONFIG_INT, Default: "3600", Description: "Seconds between retention runs, 0 disables them."},
+	{Section: "retention.*", Key: "max_days", Type: bone.CONFIG_INT, Default: "0", Description: "Age after which events of the domain expire."},
+	{Section: "retention.*", Key: "max_count", Type: bone.CONFIG_INT, Default: "0", Description: "Amount of the newest events of the domain kept."},
+	{Section: "retention.*", Key: "action", Type: bone.CONFIG_STRING, Default: "archive", Values: []string{"archive", "delete"}, Description: "What happens to expired events."},
+}
+
+// Declares the schema and reports all bad keys, returns ERROR if any value
+// has a wrong type. Unknown keys are only warned about.
+func config_init() int {
+	bone.Config.Declare(config_specs...)
+	e := OK
+	for _, problem := range bone.Config.Validate() {
+		if strings.HasPrefix(problem, "Unknown") {
+			bone.Log_Warn("%s", problem)
+			continue
+		}
+		bone.Log_Error("%s", problem)
+		e = ERROR
+	}
+	return e
+}
+
+// How often the config file is checked for modifications.
+const config_poll = 2 * time.Second
+
+// CORS middleware, replaced once `[server] cors_origins` changes.
+var cors_handler atomic.Value
+
+func cors_configure() {
+	config := cors.Config{
+		AllowMethods: []string{"GET", "POST", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Authorization"},
+	}
+	origins := strings.TrimSpace(bone.Config.String("server", "cors_origins"))
+	if origins == "*" {
+		config.AllowAllOrigins = true
+	} else {
+		for _, origin := range strings.Split(origins, ",") {
+			if strings.TrimSpace(origin) != "" {
+				config.AllowOrigins = append(config.AllowOrigins, strings.TrimSpace(origin))
+			}
+		}
+	}
+	er := config.Validate()
+	if er != nil {
+		bone.Log_Error("Invalid CORS origins '%s', error: %s", origins, er)
+		return
+	}
+	cors_handler.Store(cors.New(config))
+}
+
+func cors_middleware(c *gin.Context) {
+	cors_handler.Load().(gin.HandlerFunc)(c)
+}
+
+// Name of a key as `config` takes it, e.g. `snapshot.keep`.
+func config_key_name(spec *bone.Config_Spec) string {
+	return spec.Section + "." + spec.Key
+}
+
+func shell_config(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	if len(parts) == 0 {
+		rows := [][]string{}
+		for _, spec := range bone.Config.Specs() {
+			value, source := spec.Default, "default"
+			if !strings.HasSuffix(spec.Section, "*") {
+				value, source = bone.Config.Source(spec.Section, spec.Key)
+			}
+			rows = append(rows, []string{config_key_name(spec), value, source, spec.Description})
+		}
+		c.Table([]string{"KEY", "VALUE", "SOURCE", "DESCRIPTION"}, rows)
+		return shell.OK
+	}
+	if len(parts) != 2 {
+		c.Error("Expected `config` or `config SECTION.KEY VALUE`")
+		return shell.ERROR
+	}
+
+	i := strings.LastIndex(parts[0], ".")
+	if i <= 0 {
+		c.Error("Invalid key '%s', expected SECTION.KEY", parts[0])
+		return shell.ERROR
+	}
+	section, key := parts[0][:i], parts[0][i+1:]
+	if !bone.Config.Is_Declared(section, key) {
+		c.Error("Unknown config key '%s'", parts[0])
+		return shell.ERROR
+	}
+	if bone.Config.Write_String(section, key, parts[1]) != OK {
+		return shell.ERROR
+	}
+	c.Message("Config key '%s' set to '%s'", parts[0], parts[1])
+	return shell.OK
+}
+
+func complete_config(tokens []string) []shell.Completion {
+	if len(tokens) != 1 {
+		return nil
+	}
+	r := []shell.Completion{}
+	for _, spec := range bone.Config.Specs() {
+		if !strings.HasSuffix(spec.Section, "*") {
+			r = append(r, shell.Completion{Value: config_key_name(spec), Hint: spec.Description})
+		}
+	}
+	return r
+}
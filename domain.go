@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"seva/lib/bone"
+	"seva/lib/shell"
+)
+
+var domain_actions = []shell.Completion{
+	{Value: "create", Hint: "Creates an empty domain."},
+	{Value: "rename", Hint: "Renames domain with all of it's data."},
+	{Value: "delete", Hint: "Deletes domain with all of it's data."},
+	{Value: "copy", Hint: "Copies signatures and events into a new domain."},
+	{Value: "fork", Hint: "Copies signatures and history up to -as-of point."},
+}
+
+func complete_domain(tokens []string) []shell.Completion {
+	if len(tokens) > 1 && tokens[len(tokens)-2] == "-template" {
+		return complete_template_names()
+	}
+	if len(tokens) == 1 {
+		return domain_actions
+	}
+	if len(tokens) > 2 || tokens[0] == "create" {
+		return nil
+	}
+	r := []shell.Completion{}
+	for _, domain := range sorted_domains() {
+		r = append(r, shell.Completion{Value: domain})
+	}
+	return r
+}
+
+// Operations below run against the server in remote mode.
+
+func do_create_domain(domain string, template string) error {
+	if remote_url != "" {
+		return remote_request(context.Background(), "Domains/CreateDomain", &Rpc_Create_Domain_Args{Domain: domain, Template: template}, nil)
+	}
+	if template != "" {
+		return create_domain_from(domain, template)
+	}
+	return create_domain(domain)
+}
+
+func do_rename_domain(domain string, target string) error {
+	if remote_url != "" {
+		return remote_request(context.Background(), "Domains/RenameDomain", &Rpc_Domain_Target_Args{Domain: domain, Target: target}, nil)
+	}
+	return rename_domain(domain, target)
+}
+
+func do_delete_domain(domain string) error {
+	if remote_url != "" {
+		return remote_request(context.Background(), "Domains/DeleteDomain", &Rpc_Domain_Args{Domain: domain}, nil)
+	}
+	return delete_domain(domain)
+}
+
+// Empty `as_of` copies the whole history.
+func do_fork_domain(domain string, target string, as_of string) error {
+	if remote_url != "" && as_of == "" {
+		return remote_request(context.Background(), "Domains/CopyDomain", &Rpc_Domain_Target_Args{Domain: domain, Target: target}, nil)
+	}
+	if remote_url != "" {
+		return remote_request(context.Background(), "Domains/ForkDomain", &Rpc_Domain_Target_Args{Domain: domain, Target: target, As_Of: as_of}, nil)
+	}
+	return fork_domain(domain, target, as_of)
+}
+
+// Follows the current domain if it's renamed or deleted.
+func domain_moved(old string, new string) {
+	if shell.Get_Domain() != old {
+		return
+	}
+	shell.Set_Domain(new)
+	if remote_url == "" && !shell.Is_Captured() {
+		bone.Config.Write_String("main", "domain", new)
+	}
+}
+
+func shell_domain(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	action := parts[0]
+	names := parts[1:]
+	for i, name := range names {
+		hook, ok := shell.Resolve_Hook(name)
+		if ok {
+			names[i], _ = hook.(string)
+		}
+	}
+
+	expected := map[string]int{"create": 1, "rename": 2, "delete": 1, "copy": 2, "fork": 2}
+	n, ok := expected[action]
+	if !ok {
+		c.Error("Unrecognized domain action '%s', expected one of: create, rename, delete, copy, fork", action)
+		return shell.ERROR
+	}
+	if len(names) != n {
+		c.Error("Domain action '%s' expects %d names, got %d", action, n, len(names))
+		return shell.ERROR
+	}
+	as_of := c.Arg_String("-as-of", "")
+	if as_of != "" && action != "fork" {
+		c.Error("Flag -as-of is accepted only by fork")
+		return shell.ERROR
+	}
+	template := c.Arg_String("-template", "")
+	if template != "" && action != "create" {
+		c.Error("Flag -template is accepted only by create")
+		return shell.ERROR
+	}
+
+	var er error
+	switch action {
+	case "create":
+		er = do_create_domain(names[0], template)
+		if er == nil && template != "" {
+			c.Message("Domain '%s' created from template '%s'", names[0], template)
+		} else if er == nil {
+			c.Message("Domain '%s' created", names[0])
+		}
+	case "rename":
+		er = do_rename_domain(names[0], names[1])
+		if er == nil {
+			domain_moved(names[0], names[1])
+			c.Message("Domain '%s' renamed to '%s'", names[0], names[1])
+		}
+	case "delete":
+		text := fmt.Sprintf("Delete domain '%s' with all of it's events, archives and snapshots?", names[0])
+		return c.Confirm(text, func() int {
+			er := do_delete_domain(names[0])
+			if er != nil {
+				return c.Fail(er)
+			}
+			domain_moved(names[0], "main")
+			c.Message("Domain '%s' deleted", names[0])
+			return shell.OK
+		})
+	case "copy", "fork":
+		if action == "fork" && as_of == "" {
+			c.Error("Fork requires -as-of point, use copy for the whole history")
+			return shell.ERROR
+		}
+		er = do_fork_domain(names[0], names[1], as_of)
+		if er == nil {
+			c.Message("Domain '%s' copied to '%s'", names[0], names[1])
+		}
+	}
+	if er != nil {
+		return c.Fail(er)
+	}
+	return shell.OK
+}
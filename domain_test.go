@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"testing"
+	"time"
+)
+
+// Creates a domain of three events a minute apart, ending at the frozen
+// current time. Returns the previous clock to be restored.
+func domain_test_source() bone.Clock {
+	for _, domain := range []string{"domain_copy", "domain_renamed"} {
+		if _, ok := signatures[domain]; ok {
+			bone.Assert(delete_domain(domain) == nil)
+		}
+	}
+	test_domain("domain_test")
+	_, er := add_signature("domain_test", "NOTE", map[string]string{"text": "string"})
+	bone.Assert(er == nil)
+	clock, previous := bone.Freeze_Clock(time.Now().Add(-3 * time.Minute))
+	for _, text := range []string{"a", "b", "c"} {
+		clock.Advance(time.Minute)
+		test_event("domain_test", "NOTE", map[string]string{"text": text})
+	}
+	return previous
+}
+
+func Test_fork_domain_ok(t *testing.T) {
+	defer bone.Set_Clock(domain_test_source())
+
+	bone.Assert(fork_domain("domain_test", "domain_copy", "") == nil)
+	bone.Assert(len(events["domain_copy"]) == 3)
+	bone.Assert(chain_verify("domain_copy").Ok)
+	bone.Assert(len(test_search("domain_copy", "c", 0)) == 1)
+	// Copied events do not share fields with the source
+	events["domain_copy"][0].Fields["text"] = "changed"
+	bone.Assert(events["domain_test"][0].Fields["text"] == "a")
+	bone.Assert(delete_domain("domain_copy") == nil)
+
+	bone.Assert(fork_domain("domain_test", "domain_copy", "#2") == nil)
+	evs := events["domain_copy"]
+	bone.Assert(len(evs) == 2 && evs[1].Seq == 2 && next_seq("domain_copy") == 3)
+	bone.Assert(chain_verify("domain_copy").Ok)
+	bone.Assert(delete_domain("domain_copy") == nil)
+
+	bone.Assert(fork_domain("domain_test", "domain_copy", "-30s") == nil)
+	bone.Assert(len(events["domain_copy"]) == 2)
+}
+
+func Test_fork_domain_error(t *testing.T) {
+	defer bone.Set_Clock(domain_test_source())
+	bone.Assert(fork_domain("domain_missing", "domain_copy", "") != nil)
+	bone.Assert(fork_domain("domain_test", "domain_test", "") != nil)
+	bone.Assert(fork_domain("domain_test", "domain_copy", "sometime") != nil)
+	_, ok := signatures["domain_copy"]
+	bone.Assert(!ok)
+}
+
+func Test_rename_domain_ok(t *testing.T) {
+	defer bone.Set_Clock(domain_test_source())
+	bone.Assert(chain_checkpoint("domain_test") == nil)
+	bone.Assert(snapshot_take("domain_test") == nil)
+
+	bone.Assert(rename_domain("domain_test", "domain_renamed") == nil)
+	_, ok := signatures["domain_test"]
+	bone.Assert(!ok && len(events["domain_renamed"]) == 3)
+	// Checkpoints are signed for the new name
+	bone.Assert(chain_verify("domain_renamed").Ok)
+	bone.Assert(len(snapshot_list("domain_renamed")) == 1)
+	_, er := os.Stat(segment_dir("domain_test"))
+	bone.Assert(os.IsNotExist(er))
+	bone.Assert(segment_read_domain("domain_renamed") == nil)
+	bone.Assert(len(events["domain_renamed"]) == 3)
+
+	bone.Assert(rename_domain("main", "domain_test") != nil)
+	bone.Assert(rename_domain("domain_renamed", "bad/name") != nil)
+}
+
+func Test_rename_domain_error(t *testing.T) {
+	defer bone.Set_Clock(domain_test_source())
+	bone.Assert(chain_checkpoint("domain_test") == nil)
+	unmoved := func() {
+		_, ok := signatures["domain_test"]
+		bone.Assert(ok)
+		for _, path := range []string{bone.Userdir("signatures", "domain_test.json"), segment_dir("domain_test"), chain_checkpoints_path("domain_test")} {
+			_, er := os.Stat(path)
+			bone.Assert(er == nil)
+		}
+		_, er := os.Stat(bone.Userdir("signatures", "domain_renamed.json"))
+		bone.Assert(os.IsNotExist(er))
+		bone.Assert(chain_verify("domain_test").Ok)
+	}
+
+	// Segments cannot be moved over a non-empty directory, after the
+	// signatures are moved
+	blocked := segment_dir("domain_renamed")
+	bone.Mkdir(blocked)
+	bone.Assert(os.WriteFile(filepath.Join(blocked, "index.json"), []byte("{}"), 0644) == nil)
+	bone.Assert(errors.Is(rename_domain("domain_test", "domain_renamed"), ERR_STORAGE))
+	unmoved()
+	bone.Assert(os.RemoveAll(blocked) == nil)
+
+	// Checkpoints cannot be signed with a malformed key
+	key, er := os.ReadFile(chain_key_path())
+	bone.Assert(er == nil)
+	bone.Assert(os.WriteFile(chain_key_path(), []byte("broken"), 0600) == nil)
+	er = rename_domain("domain_test", "domain_renamed")
+	bone.Assert(os.WriteFile(chain_key_path(), key, 0600) == nil)
+	bone.Assert(errors.Is(er, ERR_CHECKPOINTS_NOT_SIGNED) && errors.Is(er, ERR_CHECKPOINT_KEY))
+	unmoved()
+	_, er = os.Stat(chain_checkpoints_path("domain_renamed"))
+	bone.Assert(os.IsNotExist(er))
+}
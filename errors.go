@@ -0,0 +1,63 @@
+package main
+
+import (
+	"seva/lib/bone"
+)
+
+// Codes are stable, clients may rely on them. OK and ERROR are taken by
+// bone, the latter stands for failures without a code.
+var (
+	ERR_INVALID_ARGUMENTS = bone.Define_Error(2, "INVALID_ARGUMENTS", "Invalid arguments: {reason}")
+	ERR_STORAGE           = bone.Define_Error(3, "STORAGE", "Cannot {action} '{path}'")
+	ERR_ENCODE            = bone.Define_Error(4, "ENCODE", "Cannot encode {what}")
+	ERR_CORRUPT           = bone.Define_Error(5, "CORRUPT", "File '{path}' is corrupt")
+	ERR_DECODE            = bone.Define_Error(6, "DECODE", "Cannot decode {what}")
+
+	ERR_DOMAIN_NOT_FOUND = bone.Define_Error(10, "DOMAIN_NOT_FOUND", "Cannot find domain '{domain}'")
+	ERR_DOMAIN_EXISTS    = bone.Define_Error(11, "DOMAIN_EXISTS", "Domain '{domain}' already exists")
+	ERR_DOMAIN_INVALID   = bone.Define_Error(12, "DOMAIN_INVALID", "Incorrect domain '{domain}'")
+	ERR_DOMAIN_MAIN      = bone.Define_Error(13, "DOMAIN_MAIN", "Domain 'main' cannot be {action}")
+
+	ERR_TYPE_MISSING           = bone.Define_Error(20, "TYPE_MISSING", "Specify at least event type")
+	ERR_SIGNATURE_NOT_FOUND    = bone.Define_Error(21, "SIGNATURE_NOT_FOUND", "Cannot find signature for type '{type}'")
+	ERR_SIGNATURE_EXISTS       = bone.Define_Error(22, "SIGNATURE_EXISTS", "Signature '{type}' already exist")
+	ERR_SIGNATURE_DEPRECATED   = bone.Define_Error(23, "SIGNATURE_DEPRECATED", "Signature '{type}' is deprecated")
+	ERR_SIGNATURE_HAS_EVENTS   = bone.Define_Error(24, "SIGNATURE_HAS_EVENTS", "Signature '{type}' has events, deprecate it instead")
+	ERR_FIELD_TYPE_UNKNOWN     = bone.Define_Error(25, "FIELD_TYPE_UNKNOWN", "Unrecognized signature value '{value}' for event '{type}'")
+	ERR_FIELD_UNKNOWN          = bone.Define_Error(30, "FIELD_UNKNOWN", "No field with key '{field}' in signature for event '{type}'")
+	ERR_FIELD_VALUE            = bone.Define_Error(31, "FIELD_VALUE", "Cannot convert value '{value}' of field '{field}' to {field_type} for event of type '{type}'")
+	ERR_INVALID_PAIR           = bone.Define_Error(32, "INVALID_PAIR", "Invalid part '{value}'")
+	ERR_INVALID_PREDICATE      = bone.Define_Error(33, "INVALID_PREDICATE", "Invalid predicate '{value}', expected e.g. key=value, key!=value, key>5 or key~text")
+	ERR_PREDICATE_KEY          = bone.Define_Error(34, "PREDICATE_KEY", "Missing field key in predicate '{value}'")
+	ERR_EVENT_NOT_FOUND        = bone.Define_Error(40, "EVENT_NOT_FOUND", "Cannot find event '{seq}'")
+	ERR_INVALID_HISTORY_POINT  = bone.Define_Error(41, "INVALID_HISTORY_POINT", "Invalid point in history '{value}', expected #SEQ or time like 2026-01-02 15:04, -2h or yesterday")
+	ERR_INVALID_TIME           = bone.Define_Error(42, "INVALID_TIME", "Invalid time '{value}': {reason}")
+	ERR_ROW_NOT_EVENT          = bone.Define_Error(43, "ROW_NOT_EVENT", "Row '{row}' of the last listing is not an event")
+	ERR_INVALID_SEQ            = bone.Define_Error(44, "INVALID_SEQ", "Invalid event sequence '{value}'")
+	ERR_CHECKPOINTS_NOT_SIGNED = bone.Define_Error(50, "CHECKPOINTS_NOT_SIGNED", "Cannot sign checkpoints of domain '{domain}'")
+	ERR_CHECKPOINT_KEY         = bone.Define_Error(51, "CHECKPOINT_KEY", "Malformed checkpoint key '{path}'")
+
+	ERR_SHELL_DISABLED     = bone.Define_Error(60, "SHELL_DISABLED", "Shell endpoints are disabled until `[server] token` is configured")
+	ERR_REMOTE             = bone.Define_Error(61, "REMOTE", "Server failed: {message}")
+	ERR_REMOTE_URL         = bone.Define_Error(62, "REMOTE_URL", "Incorrect server URL '{url}'")
+	ERR_REMOTE_UNREACHABLE = bone.Define_Error(63, "REMOTE_UNREACHABLE", "Cannot reach server '{url}'")
+	ERR_REMOTE_RESPONSE    = bone.Define_Error(64, "REMOTE_RESPONSE", "Unexpected response from '{path}', status: {status}")
+
+	ERR_SEGMENT_EMPTY       = bone.Define_Error(70, "SEGMENT_EMPTY", "Active segment of domain '{domain}' is empty")
+	ERR_SNAPSHOT_INCOMPLETE = bone.Define_Error(71, "SNAPSHOT_INCOMPLETE", "Snapshot #{seq} of domain '{domain}' misses projection '{projection}'")
+	ERR_SNAPSHOT_DIFFERS    = bone.Define_Error(72, "SNAPSHOT_DIFFERS", "Projection '{projection}' of snapshot #{seq} differs from the replayed history")
+	ERR_RETENTION_ACTION    = bone.Define_Error(73, "RETENTION_ACTION", "Unrecognized retention action '{action}' for domain '{domain}'")
+
+	ERR_TEMPLATE_NAME       = bone.Define_Error(80, "TEMPLATE_NAME", "Incorrect template name '{name}'")
+	ERR_TEMPLATE_NOT_FOUND  = bone.Define_Error(81, "TEMPLATE_NOT_FOUND", "Cannot find template '{name}'")
+	ERR_TEMPLATE_INVALID    = bone.Define_Error(82, "TEMPLATE_INVALID", "Template '{name}' is invalid")
+	ERR_TEMPLATE_PROJECTION = bone.Define_Error(83, "TEMPLATE_PROJECTION", "Template '{name}' relies on projection '{projection}', which is not available")
+
+	ERR_REPLAY_SELF       = bone.Define_Error(90, "REPLAY_SELF", "Cannot replay domain '{domain}' into itself")
+	ERR_REPLAY_FIELDS     = bone.Define_Error(91, "REPLAY_FIELDS", "Type '{type}' mapped from '{source}' has fields {fields}, while '{type}' has {existing}")
+	ERR_REPLAY_EVENT      = bone.Define_Error(92, "REPLAY_EVENT", "Cannot replay event #{seq}")
+	ERR_INVALID_MAPPING   = bone.Define_Error(93, "INVALID_MAPPING", "Invalid mapping '{value}', expected {expected}")
+	ERR_MAPPING_KEY       = bone.Define_Error(94, "MAPPING_KEY", "Missing field key in mapping '{value}'")
+	ERR_MAPPING_COLLISION = bone.Define_Error(95, "MAPPING_COLLISION", "Two fields of '{type}' are mapped to '{field}'")
+	ERR_CONVERT_TYPE      = bone.Define_Error(96, "CONVERT_TYPE", "Unrecognized type '{field_type}' to convert field '{field}' to")
+)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Operators of field predicates, two-character ones go first so `>=` is not
+// taken for `>`.
+var predicate_operators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+// Condition on a field like `total>=5`. Values are compared as numbers when
+// both sides are numbers, `~` matches a substring.
+type Predicate struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// Selects events by type and fields, all predicates have to match.
+type Event_Filter struct {
+	// Upper case type name, empty matches any type.
+	Type_Name  string
+	Predicates []*Predicate
+}
+
+func parse_predicate(token string) (*Predicate, error) {
+	for i := range token {
+		for _, op := range predicate_operators {
+			if strings.HasPrefix(token[i:], op) {
+				if i == 0 {
+					return nil, ERR_PREDICATE_KEY.With("value", token)
+				}
+				return &Predicate{Key: token[:i], Operator: op, Value: token[i+len(op):]}, nil
+			}
+		}
+	}
+	return nil, ERR_INVALID_PREDICATE.With("value", token)
+}
+
+func parse_filter(type_name string, where []string) (*Event_Filter, error) {
+	filter := &Event_Filter{Type_Name: strings.ToUpper(type_name)}
+	for _, token := range where {
+		p, er := parse_predicate(token)
+		if er != nil {
+			return nil, er
+		}
+		filter.Predicates = append(filter.Predicates, p)
+	}
+	return filter, nil
+}
+
+func (p *Predicate) match(fields map[string]string) bool {
+	value, ok := fields[p.Key]
+	if !ok {
+		return p.Operator == "!="
+	}
+
+	switch p.Operator {
+	case "=":
+		return value == p.Value
+	case "!=":
+		return value != p.Value
+	case "~":
+		return strings.Contains(value, p.Value)
+	}
+
+	a, er_a := strconv.ParseFloat(value, 64)
+	b, er_b := strconv.ParseFloat(p.Value, 64)
+	cmp := strings.Compare(value, p.Value)
+	if er_a == nil && er_b == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch p.Operator {
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// Type name is passed explicitly, since events may come from another
+// process, see `tail`.
+func (f *Event_Filter) Match(type_name string, event *Event) bool {
+	if f.Type_Name != "" && f.Type_Name != type_name {
+		return false
+	}
+	for _, p := range f.Predicates {
+		if !p.match(event.Fields) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"seva/lib/bone"
+	"testing"
+)
+
+func Test_parse_predicate_ok(t *testing.T) {
+	p, er := parse_predicate("total>=5")
+	bone.Assert(er == nil && p.Key == "total" && p.Operator == ">=" && p.Value == "5")
+	p, er = parse_predicate("note~a=b")
+	bone.Assert(er == nil && p.Key == "note" && p.Operator == "~" && p.Value == "a=b")
+	p, er = parse_predicate("id!=")
+	bone.Assert(er == nil && p.Key == "id" && p.Operator == "!=" && p.Value == "")
+
+	_, er = parse_predicate("=5")
+	bone.Assert(er != nil)
+	_, er = parse_predicate("total")
+	bone.Assert(er != nil)
+}
+
+func Test_event_filter_match_ok(t *testing.T) {
+	event := &Event{Fields: map[string]string{"total": "10", "note": "big order", "code": "b"}}
+	match := func(type_name string, where ...string) bool {
+		filter, er := parse_filter(type_name, where)
+		bone.Assert(er == nil)
+		return filter.Match("ORDER", event)
+	}
+
+	bone.Assert(match(""))
+	bone.Assert(match("order"))
+	bone.Assert(!match("PAYMENT"))
+	// Numbers are compared as numbers, not as text
+	bone.Assert(match("", "total>9"))
+	bone.Assert(match("", "total<=10", "total>=10.0"))
+	bone.Assert(!match("", "total<9"))
+	bone.Assert(match("", "code>a", "code<c"))
+	bone.Assert(match("", "note~big", "note!=small"))
+	bone.Assert(!match("", "note~big", "total=9"))
+	// Missing field matches only inequality
+	bone.Assert(match("", "missing!=1"))
+	bone.Assert(!match("", "missing=1"))
+	bone.Assert(!match("", "missing<1"))
+}
+
+func Test_tail_recent_ok(t *testing.T) {
+	sigs := []*Event_Signature{{Type_Name: "A"}, {Type_Name: "B"}}
+	evs := []*Event{{Seq: 1, Type: 1}, {Seq: 2, Type: 2}, {Seq: 3, Type: 1}, {Seq: 4, Type: 1}}
+	filter, er := parse_filter("a", nil)
+	bone.Assert(er == nil)
+	recent := tail_recent(sigs, evs, filter, 2)
+	bone.Assert(len(recent) == 2 && recent[0].Seq == 3 && recent[1].Seq == 4)
+}
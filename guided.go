@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"seva/lib/shell"
+	"sort"
+)
+
+// Values accepted by field types, shown during guided entry.
+var field_constraints = map[string]string{
+	"int":    "whole number",
+	"float":  "number",
+	"bool":   "true, false, 1 or 0",
+	"string": "any text",
+	"array":  "not validated",
+	"dict":   "not validated",
+}
+
+// Asks for each field of the signature which is not given yet, re-asking on
+// invalid values. Then shows the summary and submits the fields once the
+// user agrees.
+func guide_event(c *shell.Command_Context, signature *Event_Signature, fields map[string]string, submit func(fields map[string]string) int) int {
+	if !shell.Is_Interactive() {
+		c.Error("Guided entry requires the interactive shell")
+		return shell.ERROR
+	}
+	if signature.Deprecated {
+		c.Error("Signature '%s' is deprecated", signature.Type_Name)
+		return shell.ERROR
+	}
+
+	keys := []string{}
+	for key := range signature.Fields {
+		_, given := fields[key]
+		if !given {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var ask func(i int) int
+	ask = func(i int) int {
+		if i == len(keys) {
+			return guide_confirm(c, signature, fields, submit)
+		}
+		key := keys[i]
+		field_type := signature.Fields[key]
+		question := fmt.Sprintf("%s <%s, %s>", key, field_type, field_constraints[field_type])
+		default_, has_default := signature.Defaults[key]
+		if has_default {
+			question += fmt.Sprintf(" [%s]", default_)
+		}
+
+		shell.Ask(question+":", func(answer string) int {
+			if answer == "" {
+				if has_default {
+					fields[key] = default_
+				}
+				return ask(i + 1)
+			}
+			er := validate_field(signature.Type_Name, key, field_type, answer)
+			if er != nil {
+				c.Fail(er)
+				return ask(i)
+			}
+			fields[key] = answer
+			return ask(i + 1)
+		})
+		return shell.OK
+	}
+
+	c.Message("Fields of %s, empty answer keeps the default or skips the field, Ctrl-C cancels", signature.Type_Name)
+	return ask(0)
+}
+
+func guide_confirm(c *shell.Command_Context, signature *Event_Signature, fields map[string]string, submit func(fields map[string]string) int) int {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, fields[key])
+	}
+	c.Record(append([]string{"TYPE"}, keys...), append([]string{signature.Type_Name}, values...))
+
+	shell.Prompt(fmt.Sprintf("Add %s event?", signature.Type_Name), func(answer bool) int {
+		if !answer {
+			c.Message("Cancelled")
+			return shell.OK
+		}
+		return submit(fields)
+	})
+	return shell.OK
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"strings"
+	"testing"
+)
+
+// Runs the interactive shell over the lines in a domain with a `TASK`
+// signature, returning events it ends up with.
+func guided_test_run(lines ...string) []*Event {
+	test_domain("guided_test")
+	_, er := add_signature("guided_test", "TASK", map[string]string{
+		"title":  "string",
+		"points": "int",
+		"done":   "bool:false",
+		"tags":   "array",
+	})
+	bone.Assert(er == nil)
+	bone.Assert(shell.Set_Domain("guided_test") == OK)
+	defer shell.Set_Domain("main")
+	defer shell.Cancel()
+
+	bone.Assert(shell.Run_Scripted(strings.NewReader(strings.Join(lines, "\n"))) == OK)
+	evs, er := events_range("guided_test", 1, 0)
+	bone.Assert(er == nil)
+	return evs
+}
+
+func Test_guide_event_ok(t *testing.T) {
+	// Given fields are not asked, the rest are asked in order of their keys:
+	// done, points and tags
+	evs := guided_test_run("addevent TASK title=Write -i", "", "3", "", "y")
+	bone.Assert(len(evs) == 1)
+	// Empty answer keeps the default or skips the field
+	bone.Assert(len(evs[0].Fields) == 3)
+	bone.Assert(evs[0].Fields["title"] == "Write" && evs[0].Fields["points"] == "3" && evs[0].Fields["done"] == "false")
+
+	// All fields are asked without given ones
+	evs = guided_test_run("addevent task -i", "true", "1", "a b", "Read", "y")
+	bone.Assert(len(evs) == 1 && len(evs[0].Fields) == 4)
+	bone.Assert(evs[0].Fields["done"] == "true" && evs[0].Fields["title"] == "Read")
+}
+
+func Test_guide_event_validation(t *testing.T) {
+	// Invalid values are asked again
+	evs := guided_test_run("addevent TASK -i", "maybe", "1", "many", "2.5", "5", "", "", "y")
+	bone.Assert(len(evs) == 1)
+	bone.Assert(evs[0].Fields["done"] == "1" && evs[0].Fields["points"] == "5")
+	_, ok := evs[0].Fields["title"]
+	bone.Assert(!ok)
+}
+
+func Test_guide_event_cancel(t *testing.T) {
+	ctrl_c := string(rune(shell.KEY_CTRL_C))
+	// Ctrl-C drops the question, the next line is a command again
+	evs := guided_test_run("addevent TASK -i", "", ctrl_c, "addevent TASK title=After")
+	bone.Assert(len(evs) == 1 && evs[0].Fields["title"] == "After")
+
+	// Declined summary adds nothing
+	evs = guided_test_run("addevent TASK title=Write -i", "", "", "", "n")
+	bone.Assert(len(evs) == 0)
+	evs = guided_test_run("addevent TASK title=Write -i", "", "", "", ctrl_c, "addevent TASK title=After")
+	bone.Assert(len(evs) == 1 && evs[0].Fields["title"] == "After")
+
+	// Guided entry needs the interactive shell
+	r := shell.Execute_Captured("addevent TASK -i", "", "guided_test", "en")
+	bone.Assert(r.Code == ERROR && r.Output[0].Text == "Guided entry requires the interactive shell")
+	bone.Assert(event_count("guided_test") == 1)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"embed"
+	"os"
+	"seva/lib/bone"
+)
+
+// Translations shipped with the binary, `<userdir>/i18n/<locale>.csv` files
+// override their keys.
+//
+//go:embed i18n/*.csv
+var embedded_translations embed.FS
+
+func i18n_init() {
+	bone.Tr_Load_Dir(embedded_translations, "i18n")
+	bone.Tr_Load_Dir(os.DirFS(bone.Userdir()), "i18n")
+}
@@ -0,0 +1,483 @@
+package bone
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+type Config_Key = ini.Key
+
+const (
+	CONFIG_STRING = iota
+	CONFIG_INT
+	CONFIG_FLOAT
+	CONFIG_BOOL
+)
+
+var config_type_names = []string{"string", "int", "float", "bool"}
+
+// Declares a config key. Section may end with `*` to match sections like
+// `retention.DOMAIN`.
+type Config_Spec struct {
+	Section     string
+	Key         string
+	Type        int
+	Default     string
+	Description string
+	// Allowed values, any value of the type if empty.
+	Values []string
+}
+
+type App_Config struct {
+	path string
+	data *ini.File
+	// Guards data, which is replaced on reload while the server reads it.
+	lock  sync.RWMutex
+	specs []*Config_Spec
+	// Modification time of the file as of the last load.
+	mod_time    time.Time
+	subscribers map[string][]func()
+}
+
+var Config *App_Config
+
+func Load_Config(path string) (*App_Config, error) {
+	data, er := ini.Load(path)
+	if er != nil {
+		return nil, er
+	}
+	cfg := &App_Config{path: path, data: data, subscribers: map[string][]func(){}}
+	info, er := os.Stat(path)
+	if er == nil {
+		cfg.mod_time = info.ModTime()
+	}
+	return cfg, nil
+}
+
+func (cfg *App_Config) Declare(specs ...*Config_Spec) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.specs = append(cfg.specs, specs...)
+}
+
+func (cfg *App_Config) Specs() []*Config_Spec {
+	cfg.lock.RLock()
+	defer cfg.lock.RUnlock()
+	return append([]*Config_Spec{}, cfg.specs...)
+}
+
+func (cfg *App_Config) Is_Declared(section string, key string) bool {
+	cfg.lock.RLock()
+	defer cfg.lock.RUnlock()
+	return cfg.find_spec(section, key) != nil
+}
+
+func (cfg *App_Config) find_spec(section string, key string) *Config_Spec {
+	for _, spec := range cfg.specs {
+		if spec.Key != key {
+			continue
+		}
+		prefix, pattern := strings.CutSuffix(spec.Section, "*")
+		if spec.Section == section || (pattern && strings.HasPrefix(section, prefix)) {
+			return spec
+		}
+	}
+	return nil
+}
+
+var env_name_regex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Name of the environment variable overriding a key, e.g.
+// `SEVA_RETENTION_TELEMETRY_MAX_DAYS` for `max_days` of `retention.telemetry`.
+func Config_Env_Name(section string, key string) string {
+	name := strings.ToUpper(init_args.Project_Name + "_" + section + "_" + key)
+	return env_name_regex.ReplaceAllString(name, "_")
+}
+
+var environ_regex = regexp.MustCompile(`\$[A-Z0-9_]+`)
+
+// Return value with environs in format `$ENVIRON` are replaced by found
+// variables.
+//
+// If an environ cannot be found, the block is replaced to `ENVIRON`.
+func activate_environs(value string) string {
+	matches := environ_regex.FindAllString(value, -1)
+	for _, m := range matches {
+		environKey, found := strings.CutPrefix(m, "$")
+		if !found {
+			Log_Error("Incorrect match searching, found '%s'", m)
+			continue
+		}
+
+		environValue, found := os.LookupEnv(environKey)
+		if !found {
+			environValue = environKey
+		}
+
+		value = strings.Replace(value, m, environValue, 1)
+	}
+	return value
+}
+
+// Returns a raw value of the environment override or the file, and where it
+// comes from.
+func (cfg *App_Config) lookup(section string, key string) (string, string, bool) {
+	env := Config_Env_Name(section, key)
+	value, ok := os.LookupEnv(env)
+	if ok {
+		return value, "env " + env, true
+	}
+	cfg.lock.RLock()
+	defer cfg.lock.RUnlock()
+	s, e := cfg.data.GetSection(section)
+	if e != nil {
+		return "", "", false
+	}
+	k, e := s.GetKey(key)
+	if e != nil {
+		return "", "", false
+	}
+	return activate_environs(k.String()), "file", true
+}
+
+func check_config_value(spec *Config_Spec, value string) error {
+	var er error
+	switch spec.Type {
+	case CONFIG_INT:
+		_, er = strconv.Atoi(value)
+	case CONFIG_FLOAT:
+		_, er = strconv.ParseFloat(value, 64)
+	case CONFIG_BOOL:
+		_, er = parse_config_bool(value)
+	}
+	if er != nil {
+		return fmt.Errorf("value '%s' is not %s", value, config_type_names[spec.Type])
+	}
+	if len(spec.Values) > 0 && !slices.Contains(spec.Values, value) {
+		return fmt.Errorf("value '%s' is not one of: %s", value, strings.Join(spec.Values, ", "))
+	}
+	return nil
+}
+
+func parse_config_bool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true, nil
+	case "0", "false", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("Not a bool")
+}
+
+// Returns problems of all keys set in the file or the environment: values of
+// a wrong type and keys which are not declared. Overrides of wildcard
+// sections are found by their prefix, so a mistyped
+// `SEVA_RETENTION_TELEMETRY_MAX_DAY` is reported too.
+func (cfg *App_Config) Validate() []string {
+	problems := []string{}
+	cfg.lock.RLock()
+	type entry struct{ section, key string }
+	entries := []entry{}
+	for _, s := range cfg.data.Sections() {
+		for _, k := range s.Keys() {
+			section := s.Name()
+			if section == ini.DefaultSection {
+				section = ""
+			}
+			entries = append(entries, entry{section, k.Name()})
+		}
+	}
+	cfg.lock.RUnlock()
+	for _, spec := range cfg.Specs() {
+		if !strings.HasSuffix(spec.Section, "*") {
+			entries = append(entries, entry{spec.Section, spec.Key})
+		}
+	}
+
+	seen := map[entry]bool{}
+	// Environment variables of the checked keys
+	checked := map[string]bool{}
+	for _, e := range entries {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		checked[Config_Env_Name(e.section, e.key)] = true
+		value, source, ok := cfg.lookup(e.section, e.key)
+		if !ok {
+			continue
+		}
+		spec := cfg.find_spec(e.section, e.key)
+		if spec == nil {
+			problems = append(problems, fmt.Sprintf("Unknown config key '%s' in section [%s]", e.key, e.section))
+			continue
+		}
+		er := check_config_value(spec, value)
+		if er != nil {
+			problems = append(problems, fmt.Sprintf("Config key '%s' in section [%s] from %s: %s", e.key, e.section, source, er))
+		}
+	}
+
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if checked[name] {
+			continue
+		}
+		spec, matched := cfg.wildcard_env_spec(name)
+		if !matched {
+			continue
+		}
+		if spec == nil {
+			problems = append(problems, fmt.Sprintf("Unknown config environment variable '%s'", name))
+			continue
+		}
+		er := check_config_value(spec, value)
+		if er != nil {
+			problems = append(problems, fmt.Sprintf("Config key '%s' in section [%s] from env %s: %s", spec.Key, spec.Section, name, er))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Finds the spec of a wildcard section the environment variable overrides,
+// like `max_days` of `retention.*` for `SEVA_RETENTION_TELEMETRY_MAX_DAYS`.
+// Reports whether the variable has the prefix of a wildcard section at all,
+// so unknown keys can be told from unrelated variables. The longest key
+// wins, since section names may contain `_` as well.
+func (cfg *App_Config) wildcard_env_spec(name string) (*Config_Spec, bool) {
+	var r *Config_Spec
+	matched := false
+	for _, spec := range cfg.Specs() {
+		section, wildcard := strings.CutSuffix(spec.Section, "*")
+		if !wildcard {
+			continue
+		}
+		rest, ok := strings.CutPrefix(name, Config_Env_Name(section, ""))
+		if !ok {
+			continue
+		}
+		matched = true
+		key := env_name_regex.ReplaceAllString(strings.ToUpper(spec.Key), "_")
+		middle, ok := strings.CutSuffix(rest, "_"+key)
+		if ok && middle != "" && (r == nil || len(spec.Key) > len(r.Key)) {
+			r = spec
+		}
+	}
+	return r, matched
+}
+
+// Value of a declared key, the declared default if it's unset or invalid.
+func (cfg *App_Config) value(section string, key string) (string, *Config_Spec) {
+	cfg.lock.RLock()
+	spec := cfg.find_spec(section, key)
+	cfg.lock.RUnlock()
+	if spec == nil {
+		Log_Error("In config, key '%s' of section [%s] is not declared", key, section)
+		return "", &Config_Spec{Section: section, Key: key}
+	}
+	value, _, ok := cfg.lookup(section, key)
+	if !ok || check_config_value(spec, value) != nil {
+		return spec.Default, spec
+	}
+	return value, spec
+}
+
+// Returns the value of a declared key and where it comes from: `default`,
+// `file` or the overriding environment variable.
+func (cfg *App_Config) Source(section string, key string) (string, string) {
+	value, spec := cfg.value(section, key)
+	raw, source, ok := cfg.lookup(section, key)
+	if !ok || check_config_value(spec, raw) != nil {
+		return value, "default"
+	}
+	return value, source
+}
+
+func (cfg *App_Config) String(section string, key string) string {
+	value, _ := cfg.value(section, key)
+	return value
+}
+
+func (cfg *App_Config) Int(section string, key string) int {
+	value, _ := cfg.value(section, key)
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+func (cfg *App_Config) Float(section string, key string) float64 {
+	value, _ := cfg.value(section, key)
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+func (cfg *App_Config) Bool(section string, key string) bool {
+	value, _ := cfg.value(section, key)
+	b, _ := parse_config_bool(value)
+	return b
+}
+
+// Writes a value to the file and notifies subscribers of the section.
+// Declared keys are checked against their type first.
+func (cfg *App_Config) Write_String(module string, key string, value string) int {
+	cfg.lock.Lock()
+	spec := cfg.find_spec(module, key)
+	if spec != nil {
+		er := check_config_value(spec, value)
+		if er != nil {
+			cfg.lock.Unlock()
+			Log_Error("Cannot write config key '%s' of section [%s]: %s", key, module, er)
+			return ERROR
+		}
+	}
+
+	// Get or create the section
+	section, er := cfg.data.GetSection(module)
+	if er != nil {
+		// If the section doesn't exist, create it
+		section, er = cfg.data.NewSection(module)
+		if er != nil {
+			cfg.lock.Unlock()
+			Log_Error("In config, cannot create new section, error: %s", er)
+			return ERROR
+		}
+	}
+
+	section.Key(key).SetValue(value)
+
+	er = cfg.data.SaveTo(cfg.path)
+	if er != nil {
+		cfg.lock.Unlock()
+		Log_Error("Failed to save config, error: %s", er)
+		return ERROR
+	}
+	info, er := os.Stat(cfg.path)
+	if er == nil {
+		cfg.mod_time = info.ModTime()
+	}
+	cfg.lock.Unlock()
+
+	cfg.notify([]string{module})
+	return OK
+}
+
+func (cfg *App_Config) Write_Int(module string, key string, value int) int {
+	return cfg.Write_String(module, key, strconv.Itoa(value))
+}
+
+func (cfg *App_Config) Write_Float(module string, key string, value float64) int {
+	return cfg.Write_String(module, key, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (cfg *App_Config) Write_Bool(module string, key string, value bool) int {
+	return cfg.Write_String(module, key, strconv.FormatBool(value))
+}
+
+// Calls the function after keys of the section change, by a write or by
+// editing the file, see `Watch`. Section may end with `*` like in specs.
+func (cfg *App_Config) Subscribe(section string, fn func()) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.subscribers[section] = append(cfg.subscribers[section], fn)
+}
+
+func (cfg *App_Config) notify(sections []string) {
+	cfg.lock.RLock()
+	fns := []func(){}
+	for pattern, subscribed := range cfg.subscribers {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		for _, section := range sections {
+			if section == pattern || (wildcard && strings.HasPrefix(section, prefix)) {
+				fns = append(fns, subscribed...)
+				break
+			}
+		}
+	}
+	cfg.lock.RUnlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func config_values(data *ini.File) map[string]string {
+	r := map[string]string{}
+	for _, s := range data.Sections() {
+		for _, k := range s.Keys() {
+			r[s.Name()+"\x00"+k.Name()] = k.String()
+		}
+	}
+	return r
+}
+
+// Reloads the file if it was modified, and notifies subscribers of the
+// changed sections. Problems of the new values are logged, invalid ones
+// fall back to defaults.
+func (cfg *App_Config) Reload() int {
+	info, er := os.Stat(cfg.path)
+	if er != nil {
+		Log_Error("Cannot read config file '%s', error: %s", cfg.path, er)
+		return ERROR
+	}
+	cfg.lock.Lock()
+	if info.ModTime().Equal(cfg.mod_time) {
+		cfg.lock.Unlock()
+		return OK
+	}
+	data, er := ini.Load(cfg.path)
+	if er != nil {
+		cfg.lock.Unlock()
+		Log_Error("Cannot reload config file '%s', error: %s", cfg.path, er)
+		return ERROR
+	}
+	before := config_values(cfg.data)
+	after := config_values(data)
+	cfg.data = data
+	cfg.mod_time = info.ModTime()
+	cfg.lock.Unlock()
+
+	changed := map[string]bool{}
+	for k, v := range after {
+		if before[k] != v {
+			changed[strings.Split(k, "\x00")[0]] = true
+		}
+	}
+	for k := range before {
+		_, ok := after[k]
+		if !ok {
+			changed[strings.Split(k, "\x00")[0]] = true
+		}
+	}
+	if len(changed) == 0 {
+		return OK
+	}
+	for _, problem := range cfg.Validate() {
+		Log_Warn("%s", problem)
+	}
+	sections := []string{}
+	for section := range changed {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	Log_With("sections", strings.Join(sections, ",")).Info("Config reloaded")
+	cfg.notify(sections)
+	return OK
+}
+
+// Polls the file for modifications until the process exits.
+func (cfg *App_Config) Watch(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			cfg.Reload()
+		}
+	}()
+}
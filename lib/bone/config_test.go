@@ -0,0 +1,115 @@
+package bone
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var test_config_specs = []*Config_Spec{
+	{Section: "server", Key: "port", Type: CONFIG_INT, Default: "3000"},
+	{Section: "server", Key: "token", Type: CONFIG_STRING},
+	{Section: "log", Key: "console", Type: CONFIG_STRING, Default: "info", Values: []string{"debug", "info", "off"}},
+	{Section: "retention.*", Key: "max_days", Type: CONFIG_INT, Default: "0"},
+}
+
+// Loads the config text with the test specs declared.
+func load_test_config(t *testing.T, text string) *App_Config {
+	path := filepath.Join(t.TempDir(), "test.cfg")
+	Assert(os.WriteFile(path, []byte(text), 0644) == nil)
+	cfg, er := Load_Config(path)
+	Assert(er == nil)
+	cfg.Declare(test_config_specs...)
+	return cfg
+}
+
+func Test_config_values_ok(t *testing.T) {
+	cfg := load_test_config(t, "[server]\nport = 8080\n[retention.telemetry]\nmax_days = 90\n")
+	Assert(cfg.Int("server", "port") == 8080)
+	Assert(cfg.String("log", "console") == "info")
+	Assert(cfg.Int("retention.telemetry", "max_days") == 90)
+	Assert(cfg.Int("retention.other", "max_days") == 0)
+	value, source := cfg.Source("server", "port")
+	Assert(value == "8080" && source == "file")
+	value, source = cfg.Source("log", "console")
+	Assert(value == "info" && source == "default")
+}
+
+func Test_config_env_override_ok(t *testing.T) {
+	previous := init_args.Project_Name
+	init_args.Project_Name = "test"
+	defer func() { init_args.Project_Name = previous }()
+	Assert(Config_Env_Name("retention.telemetry", "max_days") == "TEST_RETENTION_TELEMETRY_MAX_DAYS")
+
+	t.Setenv("TEST_SERVER_PORT", "9000")
+	t.Setenv("TEST_RETENTION_TELEMETRY_MAX_DAYS", "7")
+	cfg := load_test_config(t, "[server]\nport = 8080\n")
+	value, source := cfg.Source("server", "port")
+	Assert(value == "9000" && source == "env TEST_SERVER_PORT")
+	Assert(cfg.Int("retention.telemetry", "max_days") == 7)
+
+	// Invalid override falls back to the default, not to the file
+	t.Setenv("TEST_SERVER_PORT", "many")
+	Assert(cfg.Int("server", "port") == 3000)
+}
+
+func Test_config_validate_ok(t *testing.T) {
+	cfg := load_test_config(t, "[server]\nport = 8080\n")
+	Assert(len(cfg.Validate()) == 0)
+
+	cfg = load_test_config(t, "[server]\nport = many\nhost = x\n[log]\nconsole = loud\n")
+	problems := cfg.Validate()
+	Assert(slices.Equal(problems, []string{
+		"Config key 'console' in section [log] from file: value 'loud' is not one of: debug, info, off",
+		"Config key 'port' in section [server] from file: value 'many' is not int",
+		"Unknown config key 'host' in section [server]",
+	}))
+	Assert(cfg.Int("server", "port") == 3000)
+}
+
+func Test_config_validate_env(t *testing.T) {
+	previous := init_args.Project_Name
+	init_args.Project_Name = "test"
+	defer func() { init_args.Project_Name = previous }()
+
+	// Sections of overrides need not be in the file
+	t.Setenv("TEST_RETENTION_TELEMETRY_MAX_DAYS", "7")
+	t.Setenv("TEST_RETENTION_MY_LOGS_MAX_DAYS", "7")
+	cfg := load_test_config(t, "[retention.telemetry]\nmax_days = 1\n")
+	Assert(len(cfg.Validate()) == 0)
+
+	t.Setenv("TEST_RETENTION_TELEMETRY_MAX_DAYS", "week")
+	t.Setenv("TEST_RETENTION_OTHER_MAX_DAYS", "month")
+	t.Setenv("TEST_RETENTION_TELEMETRY_MAX_DAY", "7")
+	t.Setenv("TEST_RETENTION_MAX_DAYS", "7")
+	Assert(slices.Equal(cfg.Validate(), []string{
+		"Config key 'max_days' in section [retention.*] from env TEST_RETENTION_OTHER_MAX_DAYS: value 'month' is not int",
+		"Config key 'max_days' in section [retention.telemetry] from env TEST_RETENTION_TELEMETRY_MAX_DAYS: value 'week' is not int",
+		"Unknown config environment variable 'TEST_RETENTION_MAX_DAYS'",
+		"Unknown config environment variable 'TEST_RETENTION_TELEMETRY_MAX_DAY'",
+	}))
+}
+
+func Test_config_reload_ok(t *testing.T) {
+	cfg := load_test_config(t, "[server]\nport = 8080\n")
+	notified := []string{}
+	cfg.Subscribe("server", func() { notified = append(notified, "server") })
+	cfg.Subscribe("retention.*", func() { notified = append(notified, "retention") })
+
+	// Unchanged file is not read again
+	Assert(cfg.Reload() == OK && len(notified) == 0)
+
+	Assert(os.WriteFile(cfg.path, []byte("[server]\nport = 8080\n[retention.telemetry]\nmax_days = 3\n"), 0644) == nil)
+	later := time.Now().Add(time.Minute)
+	Assert(os.Chtimes(cfg.path, later, later) == nil)
+	Assert(cfg.Reload() == OK)
+	Assert(slices.Equal(notified, []string{"retention"}))
+	Assert(cfg.Int("retention.telemetry", "max_days") == 3)
+
+	Assert(cfg.Write_Int("server", "port", 8081) == OK)
+	Assert(slices.Equal(notified, []string{"retention", "server"}))
+	Assert(cfg.Write_String("server", "port", "many") == ERROR)
+	Assert(cfg.Int("server", "port") == 8081)
+}
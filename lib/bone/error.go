@@ -1,9 +1,111 @@
-package bone
-
-import (
-	"fmt"
-)
-
-func Error(message string, args ...any) error {
-	return fmt.Errorf(message+"\n", args...)
-}
+package bone
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Failure with a stable code and a translation key, so the same failure reads
+// the same in the shell, logs and RPC responses.
+type Error struct {
+	Code int
+	// Translation key of the message, e.g. `DOMAIN_NOT_FOUND`. The text
+	// refers details as `{domain}`.
+	Key string
+	// Field, value, domain and alike, filled into the message.
+	Details map[string]any
+	Cause   error
+}
+
+// Texts of defined errors by keys, used if a translation is missing.
+var error_texts = map[string]string{}
+
+// Defines an error with the default text, details are attached to it's
+// copies by `With`.
+func Define_Error(code int, key string, text string) *Error {
+	error_texts[key] = text
+	return &Error{Code: code, Key: key}
+}
+
+func (e *Error) copy() *Error {
+	r := *e
+	r.Details = map[string]any{}
+	for k, v := range e.Details {
+		r.Details[k] = v
+	}
+	return &r
+}
+
+// Returns a copy with details given as alternating keys and values. Errors
+// are kept as their text, so details can be sent as JSON.
+func (e *Error) With(details ...any) *Error {
+	r := e.copy()
+	for i := 0; i+1 < len(details); i += 2 {
+		value := details[i+1]
+		er, ok := value.(error)
+		if ok {
+			value = er.Error()
+		}
+		r.Details[fmt.Sprint(details[i])] = value
+	}
+	return r
+}
+
+// Returns a copy caused by another error, which is appended to the message.
+func (e *Error) Wrap(cause error) *Error {
+	r := e.copy()
+	r.Cause = cause
+	return r
+}
+
+func (e *Error) Error() string {
+	return e.Text(Get_Locale())
+}
+
+// Renders the message in the locale, see `Tr_In`.
+func (e *Error) Text(locale string) string {
+	text, ok := tr_lookup(locale, e.Key)
+	if !ok {
+		text, ok = error_texts[e.Key]
+	}
+	if !ok {
+		text = e.Key
+	}
+	keys := []string{}
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		text = strings.ReplaceAll(text, "{"+k+"}", fmt.Sprint(e.Details[k]))
+	}
+	if e.Cause != nil {
+		text += ", error: " + e.Cause.Error()
+	}
+	return text
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Errors with the same code match, so `errors.Is(er, ERR_X)` ignores
+// details.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Returns OK for nil, the code of a bone error, and ERROR otherwise.
+func Error_Code(er error) int {
+	if er == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(er, &e) {
+		return e.Code
+	}
+	return ERROR
+}
@@ -0,0 +1,57 @@
+package bone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var err_test_missing = Define_Error(901, "TEST_MISSING", "Cannot find '{name}' in {place}")
+var err_test_other = Define_Error(902, "TEST_OTHER", "Other")
+
+func Test_error_with_and_wrap_ok(t *testing.T) {
+	er := err_test_missing.With("name", "apple", "place", "basket")
+	Assert(er.Text("en") == "Cannot find 'apple' in basket")
+	// Details are attached to copies only
+	Assert(len(err_test_missing.Details) == 0)
+	Assert(er.With("name", "pear").Text("en") == "Cannot find 'pear' in basket")
+	Assert(er.Text("en") == "Cannot find 'apple' in basket")
+
+	// Errors are kept as their text
+	er = err_test_missing.With("name", fmt.Errorf("boom"))
+	_, ok := er.Details["name"].(string)
+	Assert(ok)
+
+	cause := fmt.Errorf("disk is full")
+	wrapped := err_test_missing.With("name", "apple", "place", "basket").Wrap(cause)
+	Assert(wrapped.Text("en") == "Cannot find 'apple' in basket, error: disk is full")
+	Assert(errors.Unwrap(wrapped) == cause)
+	Assert(err_test_missing.Cause == nil)
+}
+
+func Test_error_is_and_code_ok(t *testing.T) {
+	er := err_test_missing.With("name", "apple")
+	Assert(errors.Is(er, err_test_missing))
+	Assert(!errors.Is(er, err_test_other))
+	// Matched through wrapping
+	outer := err_test_other.Wrap(er)
+	Assert(errors.Is(outer, err_test_missing))
+	Assert(errors.Is(fmt.Errorf("context: %w", er), err_test_missing))
+
+	Assert(Error_Code(nil) == OK)
+	Assert(Error_Code(er) == 901)
+	Assert(Error_Code(fmt.Errorf("context: %w", er)) == 901)
+	Assert(Error_Code(fmt.Errorf("plain")) == ERROR)
+}
+
+func Test_error_text_locale_ok(t *testing.T) {
+	Assert(tr_load(strings.NewReader("key,text\nTEST_MISSING,Не найдено '{name}' в {place}\n"), "ru", ','))
+
+	er := err_test_missing.With("name", "apple", "place", "basket")
+	Assert(er.Text("ru") == "Не найдено 'apple' в basket")
+	Assert(er.Text("ru-RU") == "Не найдено 'apple' в basket")
+	// Missing translation falls back to the defined text
+	Assert(er.Text("de") == "Cannot find 'apple' in basket")
+	Assert(err_test_other.Text("ru") == "Other")
+}
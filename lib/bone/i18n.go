@@ -1,83 +1,290 @@
-package bone
-
-import (
-	"encoding/csv"
-	"fmt"
-	"os"
-	"strings"
-)
-
-// {locale: {key: value}}
-var translationMap = map[string]map[string]string{}
-var translationLocale string = "en"
-
-// Register a translation from a CSV file.
-// CSV file structure:
-// key(string),text(string)
-//
-// This function can be called many times, each new call the old matching
-// entries will be overwritten.
-//
-// Text may contain placeholders in form of `%` to accept incoming value,
-// which will always be converted to string.
-//
-// For list of locales refer to https://docs.godotengine.org/en/4.3/tutorials/i18n/locales.html
-func TrLoadCsv(path string, locale string, delimiter rune) bool {
-	locale = strings.ToLower(locale)
-
-	file, e := os.Open(path)
-	if e != nil {
-		return false
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.Comma = delimiter
-	records, e := reader.ReadAll()
-	if e != nil {
-		return false
-	}
-
-	localeMap, ok := translationMap[locale]
-	if !ok {
-		localeMap = map[string]string{}
-		translationMap[locale] = localeMap
-	}
-
-	for i, record := range records {
-		if len(record) != 2 {
-			return false
-		}
-		if i == 0 {
-			continue
-		}
-		localeMap[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
-	}
-
-	return true
-}
-
-func Tr(key string) string {
-	t, ok := TrOrError(key)
-	if !ok {
-		return key
-	}
-	return t
-}
-
-func Tr_Code(code int) string {
-	return Tr(fmt.Sprintf("CODE_%d", code))
-}
-
-func TrOrError(key string) (string, bool) {
-	key = strings.ToUpper(key)
-	localeMap, ok := translationMap[translationLocale]
-	if !ok {
-		return "", false
-	}
-	text, ok := localeMap[strings.ToUpper(key)]
-	if !ok {
-		return "", false
-	}
-	return text, true
-}
+package bone
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// {locale: {key: value}}
+var translationMap = map[string]map[string]string{}
+var translationLocale string = "en"
+
+// Locale used when a key is missing in the requested one and it's base
+// language.
+var translationFallback string = "en"
+var translationLock sync.RWMutex
+
+var i18n_config_specs = []*Config_Spec{
+	{Section: "i18n", Key: "locale", Type: CONFIG_STRING, Default: "en", Description: "Locale of messages, e.g. `en` or `ru`."},
+	{Section: "i18n", Key: "fallback", Type: CONFIG_STRING, Default: "en", Description: "Locale used for keys missing in the current one."},
+}
+
+func i18n_configure() {
+	Set_Locale(Config.String("i18n", "locale"))
+	translationLock.Lock()
+	translationFallback = strings.ToLower(Config.String("i18n", "fallback"))
+	translationLock.Unlock()
+}
+
+// Register a translation from a CSV file.
+// CSV file structure:
+// key(string),text(string)
+//
+// This function can be called many times, each new call the old matching
+// entries will be overwritten.
+//
+// Text may contain `fmt` placeholders like `%s` or `%d`, filled by arguments
+// of `Tr`. Plural forms are stored under keys suffixed by `#one`, `#few`,
+// `#many` or `#other`, see `Tr_Plural`.
+//
+// For list of locales refer to https://docs.godotengine.org/en/4.3/tutorials/i18n/locales.html
+func TrLoadCsv(path string, locale string, delimiter rune) bool {
+	file, e := os.Open(path)
+	if e != nil {
+		return false
+	}
+	defer file.Close()
+	return tr_load(file, locale, delimiter)
+}
+
+func tr_load(reader io.Reader, locale string, delimiter rune) bool {
+	locale = strings.ToLower(locale)
+	csv_reader := csv.NewReader(reader)
+	csv_reader.Comma = delimiter
+	records, e := csv_reader.ReadAll()
+	if e != nil {
+		return false
+	}
+
+	translationLock.Lock()
+	defer translationLock.Unlock()
+	localeMap, ok := translationMap[locale]
+	if !ok {
+		localeMap = map[string]string{}
+		translationMap[locale] = localeMap
+	}
+
+	for i, record := range records {
+		if len(record) != 2 {
+			return false
+		}
+		if i == 0 {
+			continue
+		}
+		localeMap[strings.ToUpper(strings.TrimSpace(record[0]))] = strings.TrimSpace(record[1])
+	}
+
+	return true
+}
+
+// Loads every `<locale>.csv` of the directory, returns amount of loaded
+// files. Files are comma separated, like the ones of `TrLoadCsv`.
+func Tr_Load_Dir(fsys fs.FS, dir string) int {
+	entries, e := fs.ReadDir(fsys, dir)
+	if e != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		locale, ok := strings.CutSuffix(entry.Name(), ".csv")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		data, e := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if e != nil || !tr_load(bytes.NewReader(data), locale, ',') {
+			Log_Error("Cannot load translations '%s'", path.Join(dir, entry.Name()))
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func Set_Locale(locale string) {
+	translationLock.Lock()
+	defer translationLock.Unlock()
+	translationLocale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+func Get_Locale() string {
+	translationLock.RLock()
+	defer translationLock.RUnlock()
+	return translationLocale
+}
+
+// Returns locales looked up for the given one, e.g. `de-at`, `de`, then the
+// fallback.
+func locale_chain(locale string) []string {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	chain := []string{locale}
+	base, _, ok := strings.Cut(locale, "-")
+	if ok {
+		chain = append(chain, base)
+	}
+	if translationFallback != base && translationFallback != locale {
+		chain = append(chain, translationFallback)
+	}
+	return chain
+}
+
+// Picks the best loaded locale from an `Accept-Language` header like
+// `de-AT,de;q=0.9,en;q=0.5`, returns the current locale if none is loaded.
+func Match_Locale(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	candidates := []candidate{}
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			fmt.Sscanf(params[2:], "%g", &q)
+		}
+		if tag != "" && tag != "*" {
+			candidates = append(candidates, candidate{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	translationLock.RLock()
+	defer translationLock.RUnlock()
+	for _, c := range candidates {
+		_, ok := translationMap[c.locale]
+		if ok {
+			return c.locale
+		}
+		base, _, _ := strings.Cut(c.locale, "-")
+		_, ok = translationMap[base]
+		if ok {
+			return base
+		}
+	}
+	return translationLocale
+}
+
+func Tr(key string, args ...any) string {
+	return Tr_In(Get_Locale(), key, args...)
+}
+
+// Translates into the locale, falling back to it's base language and the
+// fallback locale. Missing key is returned as is, so English texts may serve
+// as keys.
+func Tr_In(locale string, key string, args ...any) string {
+	text, ok := tr_lookup(locale, key)
+	if !ok {
+		text = key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+func tr_lookup(locale string, key string) (string, bool) {
+	key = strings.ToUpper(key)
+	translationLock.RLock()
+	defer translationLock.RUnlock()
+	for _, l := range locale_chain(locale) {
+		text, ok := translationMap[l][key]
+		if ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// Plural categories by languages, English rule applies to the rest.
+var plural_rules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"fr": func(n int) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"ru": plural_slavic,
+	"uk": plural_slavic,
+	"pl": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		if n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14) {
+			return "few"
+		}
+		return "many"
+	},
+}
+
+func plural_slavic(n int) string {
+	if n%10 == 1 && n%100 != 11 {
+		return "one"
+	}
+	if n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14) {
+		return "few"
+	}
+	return "many"
+}
+
+func plural_form(locale string, n int) string {
+	base, _, _ := strings.Cut(locale, "-")
+	rule, ok := plural_rules[base]
+	if !ok {
+		rule = plural_rules["en"]
+	}
+	if n < 0 {
+		n = -n
+	}
+	return rule(n)
+}
+
+func Tr_Plural(key string, n int, args ...any) string {
+	return Tr_Plural_In(Get_Locale(), key, n, args...)
+}
+
+// Translates the form of the key for the amount, e.g. `KEY#one`. Missing
+// form falls back to `KEY#other`, then to the key itself.
+func Tr_Plural_In(locale string, key string, n int, args ...any) string {
+	translationLock.RLock()
+	text, ok := "", false
+	for _, l := range locale_chain(locale) {
+		for _, form := range []string{plural_form(l, n), "other"} {
+			text, ok = translationMap[l][strings.ToUpper(key+"#"+form)]
+			if ok {
+				break
+			}
+		}
+		if ok {
+			break
+		}
+	}
+	translationLock.RUnlock()
+	if !ok {
+		return Tr_In(locale, key, args...)
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+func Tr_Code(code int) string {
+	return Tr(fmt.Sprintf("CODE_%d", code))
+}
+
+func TrOrError(key string) (string, bool) {
+	return tr_lookup(Get_Locale(), key)
+}
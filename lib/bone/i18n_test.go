@@ -0,0 +1,18 @@
+package bone
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_tr_plural_and_fallback_ok(t *testing.T) {
+	Assert(tr_load(strings.NewReader("key,text\n%d files#one,%d file\nHELLO,\"Hello, %s\"\n"), "en", ','))
+	Assert(tr_load(strings.NewReader("key,text\n%d files#one,%d файл\n%d files#few,%d файла\n%d files#many,%d файлов\n"), "ru", ','))
+
+	Assert(Tr_Plural_In("en", "%d files", 1, 1) == "1 file")
+	Assert(Tr_Plural_In("en", "%d files", 2, 2) == "2 files")
+	Assert(Tr_Plural_In("ru-RU", "%d files", 22, 22) == "22 файла")
+	Assert(Tr_Plural_In("ru", "%d files", 11, 11) == "11 файлов")
+	Assert(Tr_In("ru-RU", "hello", "Bob") == "Hello, Bob")
+	Assert(Match_Locale("de-AT,ru;q=0.8,en;q=0.5") == "ru")
+}
@@ -1,16 +1,355 @@
-package bone
-
-import "fmt"
-
-// @todo use loguru-like sinks
-
-func Log(message string, args ...any) {
-	fmt.Printf(message+"\n", args...)
-}
-
-func Log_Error(message string, args ...any) {
-	const RED = "\033[91m"
-	const RESET = "\033[0m"
-	message = fmt.Sprintf(message, args...)
-	fmt.Printf("%sERROR%s: %s\n", RED, RESET, message)
-}
+package bone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+	// Level of a disabled sink.
+	LOG_OFF
+)
+
+var log_level_names = []string{"debug", "info", "warn", "error", "off"}
+
+// Disable to print without ANSI colors, e.g. if output is not a terminal.
+var Log_Colors = true
+
+// Receives console messages instead of the standard output when set, e.g. to
+// return output of a shell command over RPC. Other sinks still get them.
+var Log_Redirect func(is_error bool, message string)
+
+type Log_Record struct {
+	Time    time.Time
+	Level   int
+	Message string
+	// Alternating keys and values.
+	Fields []any
+}
+
+// Destination of log records, records below the level are skipped.
+type Log_Sink interface {
+	Level() int
+	Write(record *Log_Record)
+	Close()
+}
+
+var log_sinks = []Log_Sink{}
+var log_lock sync.Mutex
+
+// Parses a level name like `warn`, returns LOG_OFF for unknown names.
+func Parse_Log_Level(name string) int {
+	for i, level := range log_level_names {
+		if strings.EqualFold(name, level) {
+			return i
+		}
+	}
+	return LOG_OFF
+}
+
+// Replaces sinks, closing the previous ones.
+func Set_Log_Sinks(sinks ...Log_Sink) {
+	log_lock.Lock()
+	defer log_lock.Unlock()
+	for _, sink := range log_sinks {
+		sink.Close()
+	}
+	log_sinks = sinks
+}
+
+var log_config_specs = []*Config_Spec{
+	{Section: "log", Key: "console", Type: CONFIG_STRING, Default: "info", Values: log_level_names, Description: "Level of the colored standard output."},
+	{Section: "log", Key: "file", Type: CONFIG_STRING, Default: "off", Values: log_level_names, Description: "Level of the rotating text file at `logs/<project>.log`."},
+	{Section: "log", Key: "json", Type: CONFIG_STRING, Default: "off", Values: log_level_names, Description: "Level of JSON lines at `logs/<project>.jsonl`."},
+	{Section: "log", Key: "max_size_kb", Type: CONFIG_INT, Default: "1024", Description: "Size of a log file after which it's rotated."},
+	{Section: "log", Key: "keep_files", Type: CONFIG_INT, Default: "5", Description: "Amount of rotated log files kept."},
+}
+
+// Sets sinks up from the `[log]` section of the config.
+func Log_Configure() {
+	sinks := []Log_Sink{&Console_Sink{Min_Level: Parse_Log_Level(Config.String("log", "console"))}}
+	max_size := int64(Config.Int("log", "max_size_kb")) * 1024
+	keep := Config.Int("log", "keep_files")
+	file := Parse_Log_Level(Config.String("log", "file"))
+	if file != LOG_OFF {
+		sinks = append(sinks, &File_Sink{Min_Level: file, Path: Userdir("logs", init_args.Project_Name+".log"), Max_Size: max_size, Keep: keep})
+	}
+	json_level := Parse_Log_Level(Config.String("log", "json"))
+	if json_level != LOG_OFF {
+		sinks = append(sinks, &File_Sink{Min_Level: json_level, Path: Userdir("logs", init_args.Project_Name+".jsonl"), Max_Size: max_size, Keep: keep, Json: true})
+	}
+	Set_Log_Sinks(sinks...)
+}
+
+func Log_Close() {
+	Set_Log_Sinks()
+}
+
+func log_write(level int, fields []any, message string) {
+	record := &Log_Record{Time: Now(), Level: level, Message: message, Fields: fields}
+	log_lock.Lock()
+	defer log_lock.Unlock()
+	if len(log_sinks) == 0 {
+		// Logging before the configuration is read goes to the console
+		(&Console_Sink{Min_Level: LOG_INFO}).Write(record)
+		return
+	}
+	for _, sink := range log_sinks {
+		if level >= sink.Level() {
+			sink.Write(record)
+		}
+	}
+}
+
+// Logger with fields attached to each record, e.g.
+// `Log_With("domain", domain).Warn("Snapshot %d is broken", seq)`.
+type Logger struct {
+	fields []any
+}
+
+func Log_With(fields ...any) *Logger {
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) With(fields ...any) *Logger {
+	return &Logger{fields: append(append([]any{}, l.fields...), fields...)}
+}
+
+func (l *Logger) Debug(message string, args ...any) {
+	log_write(LOG_DEBUG, l.fields, fmt.Sprintf(message, args...))
+}
+
+func (l *Logger) Info(message string, args ...any) {
+	log_write(LOG_INFO, l.fields, fmt.Sprintf(message, args...))
+}
+
+func (l *Logger) Warn(message string, args ...any) {
+	log_write(LOG_WARN, l.fields, fmt.Sprintf(message, args...))
+}
+
+func (l *Logger) Error(message string, args ...any) {
+	log_write(LOG_ERROR, l.fields, fmt.Sprintf(message, args...))
+}
+
+// Prints output meant for the user, like tables of shell commands, so it's
+// not passed to sinks.
+func Log(message string, args ...any) {
+	if Log_Redirect != nil {
+		Log_Redirect(false, fmt.Sprintf(message, args...))
+		return
+	}
+	fmt.Printf(message+"\n", args...)
+}
+
+// Prints an error meant for the user, like a failed shell command, to the
+// standard error. Same as `Log`, it's not passed to sinks, so it's shown
+// whatever the log level is.
+func Log_User_Error(message string, args ...any) {
+	text := fmt.Sprintf(message, args...)
+	if Log_Redirect != nil {
+		Log_Redirect(true, text)
+		return
+	}
+	prefix := "ERROR"
+	if Log_Colors {
+		prefix = "\033[91m" + prefix + "\033[0m"
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, text)
+}
+
+func Log_Debug(message string, args ...any) {
+	log_write(LOG_DEBUG, nil, fmt.Sprintf(message, args...))
+}
+
+func Log_Info(message string, args ...any) {
+	log_write(LOG_INFO, nil, fmt.Sprintf(message, args...))
+}
+
+func Log_Warn(message string, args ...any) {
+	log_write(LOG_WARN, nil, fmt.Sprintf(message, args...))
+}
+
+func Log_Error(message string, args ...any) {
+	log_write(LOG_ERROR, nil, fmt.Sprintf(message, args...))
+}
+
+// Returns a writer logging each written line at the level, for libraries
+// printing to an `io.Writer`.
+func Log_Writer(level int) io.Writer {
+	return &log_line_writer{level: level}
+}
+
+type log_line_writer struct {
+	level int
+}
+
+func (w *log_line_writer) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line != "" {
+			log_write(w.level, nil, line)
+		}
+	}
+	return len(data), nil
+}
+
+func format_fields(fields []any) string {
+	parts := []string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", fields[i], fields[i+1]))
+	}
+	if len(fields)%2 == 1 {
+		parts = append(parts, fmt.Sprintf("%v", fields[len(fields)-1]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Prints to the standard output, info records go without a level prefix,
+// since most of them are addressed to the user.
+type Console_Sink struct {
+	Min_Level int
+}
+
+func (s *Console_Sink) Level() int {
+	return s.Min_Level
+}
+
+func (s *Console_Sink) Write(record *Log_Record) {
+	const RESET = "\033[0m"
+	colors := []string{"\033[90m", "", "\033[93m", "\033[91m"}
+	text := record.Message
+	fields := format_fields(record.Fields)
+	if Log_Redirect != nil {
+		if fields != "" {
+			text += " " + fields
+		}
+		Log_Redirect(record.Level >= LOG_WARN, text)
+		return
+	}
+
+	if fields != "" {
+		if Log_Colors {
+			fields = "\033[90m" + fields + RESET
+		}
+		text += " " + fields
+	}
+	if record.Level != LOG_INFO {
+		prefix := strings.ToUpper(log_level_names[record.Level])
+		if Log_Colors {
+			prefix = colors[record.Level] + prefix + RESET
+		}
+		text = prefix + ": " + text
+	}
+	fmt.Println(text)
+}
+
+func (s *Console_Sink) Close() {}
+
+// Appends records to a file as text or JSON lines. Once the file grows over
+// `Max_Size`, it's renamed to `<path>.1`, older files are shifted, and only
+// `Keep` of them stay.
+type File_Sink struct {
+	Min_Level int
+	Path      string
+	Max_Size  int64
+	Keep      int
+	Json      bool
+
+	file *os.File
+	size int64
+}
+
+func (s *File_Sink) Level() int {
+	return s.Min_Level
+}
+
+func (s *File_Sink) open() bool {
+	Mkdir(filepath.Dir(s.Path))
+	f, er := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if er != nil {
+		fmt.Printf("ERROR: Cannot open log file '%s': %s\n", s.Path, er)
+		s.Min_Level = LOG_OFF
+		return false
+	}
+	info, er := f.Stat()
+	if er == nil {
+		s.size = info.Size()
+	}
+	s.file = f
+	return true
+}
+
+func (s *File_Sink) rotate() {
+	s.file.Close()
+	s.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", s.Path, s.Keep))
+	for i := s.Keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.Path, i), fmt.Sprintf("%s.%d", s.Path, i+1))
+	}
+	if s.Keep > 0 {
+		os.Rename(s.Path, s.Path+".1")
+	} else {
+		os.Remove(s.Path)
+	}
+}
+
+func (s *File_Sink) format(record *Log_Record) string {
+	if !s.Json {
+		line := fmt.Sprintf("%s %-5s %s", record.Time.Format("2006-01-02 15:04:05.000"), strings.ToUpper(log_level_names[record.Level]), record.Message)
+		fields := format_fields(record.Fields)
+		if fields != "" {
+			line += " " + fields
+		}
+		return line + "\n"
+	}
+
+	entry := map[string]any{
+		"time":    record.Time.Format(time.RFC3339Nano),
+		"level":   log_level_names[record.Level],
+		"message": record.Message,
+	}
+	for i := 0; i+1 < len(record.Fields); i += 2 {
+		key := fmt.Sprintf("%v", record.Fields[i])
+		value := record.Fields[i+1]
+		if er, ok := value.(error); ok {
+			value = er.Error()
+		}
+		entry[key] = value
+	}
+	data, er := json.Marshal(entry)
+	if er != nil {
+		data, _ = json.Marshal(map[string]any{"time": entry["time"], "level": entry["level"], "message": record.Message})
+	}
+	return string(data) + "\n"
+}
+
+func (s *File_Sink) Write(record *Log_Record) {
+	if s.file == nil && !s.open() {
+		return
+	}
+	line := s.format(record)
+	if s.Max_Size > 0 && s.size > 0 && s.size+int64(len(line)) > s.Max_Size {
+		s.rotate()
+		if !s.open() {
+			return
+		}
+	}
+	n, _ := s.file.WriteString(line)
+	s.size += int64(n)
+}
+
+func (s *File_Sink) Close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
@@ -0,0 +1,31 @@
+package bone
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_file_sink_rotates_ok(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jsonl")
+	sink := &File_Sink{Min_Level: LOG_DEBUG, Path: path, Max_Size: 200, Keep: 2, Json: true}
+	defer sink.Close()
+	for i := 0; i < 10; i++ {
+		sink.Write(&Log_Record{Time: time.Now(), Level: LOG_WARN, Message: "hello", Fields: []any{"i", i}})
+	}
+
+	data, er := os.ReadFile(path)
+	Assert(er == nil)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last map[string]any
+	Assert(json.Unmarshal([]byte(lines[len(lines)-1]), &last) == nil)
+	Assert(last["level"] == "warn" && last["message"] == "hello" && last["i"] == float64(9))
+
+	_, er = os.Stat(path + ".2")
+	Assert(er == nil)
+	_, er = os.Stat(path + ".3")
+	Assert(os.IsNotExist(er))
+}
@@ -1,17 +1,107 @@
-// Time is in milliseconds, unless other is clearly specified.
-package bone
-
-import "time"
-
-func Utc() int64 {
-	return time.Now().Unix()
-}
-
-// Formats timestamp to a date.
-func Date_Sec(sec int, format string) string {
-	return time.Unix(int64(sec), 0).Format(format)
-}
-
-func Sleep_Ms(duration_ms int64) {
-	time.Sleep(time.Duration(duration_ms) * time.Millisecond)
-}
+// Time is in milliseconds, unless other is clearly specified.
+package bone
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of the current time, replaced in tests by `Freeze_Clock`.
+type Clock interface {
+	Now() time.Time
+}
+
+type system_clock struct{}
+
+func (system_clock) Now() time.Time {
+	return time.Now()
+}
+
+var clock Clock = system_clock{}
+var clock_lock sync.RWMutex
+
+// Timezone dates are shown and parsed in.
+var location = time.Local
+
+var time_config_specs = []*Config_Spec{
+	{Section: "time", Key: "timezone", Type: CONFIG_STRING, Default: "Local", Description: "Timezone dates are shown and parsed in, e.g. `UTC` or `Europe/Berlin`."},
+}
+
+func time_configure() {
+	name := Config.String("time", "timezone")
+	loc, er := time.LoadLocation(name)
+	if er != nil {
+		Log_Error("Unknown timezone '%s', using the local one", name)
+		loc = time.Local
+	}
+	clock_lock.Lock()
+	defer clock_lock.Unlock()
+	location = loc
+}
+
+func Location() *time.Location {
+	clock_lock.RLock()
+	defer clock_lock.RUnlock()
+	return location
+}
+
+// Replaces the clock, returning the previous one.
+func Set_Clock(c Clock) Clock {
+	clock_lock.Lock()
+	defer clock_lock.Unlock()
+	previous := clock
+	clock = c
+	return previous
+}
+
+func Now() time.Time {
+	clock_lock.RLock()
+	defer clock_lock.RUnlock()
+	return clock.Now()
+}
+
+// Clock standing still until it's advanced.
+type Frozen_Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// Sets a frozen clock at the time, `Set_Clock(previous)` restores the real
+// one.
+func Freeze_Clock(at time.Time) (frozen *Frozen_Clock, previous Clock) {
+	frozen = &Frozen_Clock{now: at}
+	return frozen, Set_Clock(frozen)
+}
+
+func (c *Frozen_Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *Frozen_Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Utc() int64 {
+	return Now().UnixMilli()
+}
+
+func Utc_Sec() int64 {
+	return Now().Unix()
+}
+
+// Formats timestamp to a date in the display timezone.
+func Date_Sec(sec int, format string) string {
+	return time.Unix(int64(sec), 0).In(Location()).Format(format)
+}
+
+func Date_Ms(ms int64, format string) string {
+	return time.UnixMilli(ms).In(Location()).Format(format)
+}
+
+func Sleep_Ms(duration_ms int64) {
+	time.Sleep(time.Duration(duration_ms) * time.Millisecond)
+}
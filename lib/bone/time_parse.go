@@ -0,0 +1,166 @@
+package bone
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of absolute times by the length of the period they denote.
+var time_layouts = []struct {
+	layout string
+	period func(t time.Time) time.Time
+}{
+	{"2006-01-02 15:04:05.000", func(t time.Time) time.Time { return t.Add(time.Millisecond) }},
+	{"2006-01-02T15:04:05.000", func(t time.Time) time.Time { return t.Add(time.Millisecond) }},
+	{"2006-01-02 15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{"2006-01-02 15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// Offsets like `-2h`, `+1d` or `3w ago`.
+var time_offset_regex = regexp.MustCompile(`^([+-]?)(\d+)\s*(ms|s|m|h|d|w)(\s+ago)?$`)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+var Err_Time_Unrecognized = errors.New("unrecognized time expression")
+var Err_Time_Range = errors.New("range ends before it starts")
+
+// Parses a point in time: `now`, dates like `2026-01-02`, `2026-01` or
+// `2026-01-02 15:04`, RFC3339, offsets from now like `-2h`, `+1d` or `3w ago`,
+// `today`, `yesterday`, `tomorrow`, and `last monday`, `this week` or
+// `next month`. Times without a zone are in the display timezone, see
+// `Location`.
+func Parse_Time(text string) (time.Time, error) {
+	from, _, er := parse_period(text, Now().In(Location()))
+	return from, er
+}
+
+// Parses a range like `2026-01..2026-03`, ends are whole periods, so the
+// range lasts till April. The end is exclusive. Either end may be omitted,
+// then it's the zero time. Single expression is a range of it's period, e.g.
+// `yesterday` is the whole day, while a point like `-2h` starts an open
+// range.
+func Parse_Time_Range(text string) (from time.Time, to time.Time, er error) {
+	now := Now().In(Location())
+	first, last, ok := strings.Cut(text, "..")
+	if !ok {
+		from, to, er = parse_period(text, now)
+		if er == nil && from.Equal(to) {
+			to = time.Time{}
+		}
+		return from, to, er
+	}
+	if strings.TrimSpace(first) != "" {
+		from, _, er = parse_period(first, now)
+		if er != nil {
+			return from, to, er
+		}
+	}
+	if strings.TrimSpace(last) != "" {
+		_, to, er = parse_period(last, now)
+		if er != nil {
+			return from, to, er
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, Err_Time_Range
+	}
+	return from, to, nil
+}
+
+// Returns start and exclusive end of the period denoted by the text, points
+// like `now` or `-2h` end where they start.
+func parse_period(text string, now time.Time) (time.Time, time.Time, error) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := func(offset int) (time.Time, time.Time, error) {
+		start := today.AddDate(0, 0, offset)
+		return start, start.AddDate(0, 0, 1), nil
+	}
+	switch text {
+	case "now":
+		return now, now, nil
+	case "today":
+		return day(0)
+	case "yesterday":
+		return day(-1)
+	case "tomorrow":
+		return day(1)
+	}
+
+	t, er := time.Parse(time.RFC3339Nano, strings.ToUpper(text))
+	if er == nil {
+		return t, t, nil
+	}
+	for _, l := range time_layouts {
+		t, er := time.ParseInLocation(l.layout, text, now.Location())
+		if er == nil {
+			return t, l.period(t), nil
+		}
+	}
+
+	match := time_offset_regex.FindStringSubmatch(text)
+	if match != nil {
+		n, er := strconv.Atoi(match[2])
+		if er != nil {
+			return now, now, Err_Time_Unrecognized
+		}
+		if match[1] == "-" || match[4] != "" {
+			n = -n
+		}
+		var t time.Time
+		switch match[3] {
+		case "d":
+			t = now.AddDate(0, 0, n)
+		case "w":
+			t = now.AddDate(0, 0, 7*n)
+		default:
+			unit, _ := time.ParseDuration("1" + match[3])
+			t = now.Add(time.Duration(n) * unit)
+		}
+		return t, t, nil
+	}
+
+	relation, unit, ok := strings.Cut(text, " ")
+	shift := map[string]int{"last": -1, "this": 0, "next": 1}
+	n, known := shift[relation]
+	if !ok || !known {
+		return now, now, Err_Time_Unrecognized
+	}
+	weekday, ok := weekdays[unit]
+	if ok {
+		offset := int(weekday - today.Weekday())
+		switch {
+		case n < 0 && offset >= 0:
+			offset -= 7
+		case n > 0 && offset <= 0:
+			offset += 7
+		case n == 0:
+			// Weeks start on Monday
+			offset = (int(weekday)+6)%7 - (int(today.Weekday())+6)%7
+		}
+		return day(offset)
+	}
+	switch unit {
+	case "week":
+		start := today.AddDate(0, 0, -(int(today.Weekday())+6)%7+7*n)
+		return start, start.AddDate(0, 0, 7), nil
+	case "month":
+		start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, n, 0)
+		return start, start.AddDate(0, 1, 0), nil
+	case "year":
+		start := time.Date(today.Year()+n, 1, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(1, 0, 0), nil
+	}
+	return now, now, Err_Time_Unrecognized
+}
@@ -0,0 +1,36 @@
+package bone
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parse_time_expressions_ok(t *testing.T) {
+	// Wednesday
+	_, previous := Freeze_Clock(time.Date(2026, 3, 18, 10, 30, 0, 0, Location()))
+	defer Set_Clock(previous)
+
+	at := func(text string) time.Time {
+		r, er := Parse_Time(text)
+		Assert(er == nil, "Cannot parse '%s': %s", text, er)
+		return r
+	}
+	Assert(at("-2h").Equal(time.Date(2026, 3, 18, 8, 30, 0, 0, Location())))
+	Assert(at("3d ago").Equal(time.Date(2026, 3, 15, 10, 30, 0, 0, Location())))
+	Assert(at("yesterday").Equal(time.Date(2026, 3, 17, 0, 0, 0, 0, Location())))
+	Assert(at("last monday").Equal(time.Date(2026, 3, 16, 0, 0, 0, 0, Location())))
+	Assert(at("last wednesday").Equal(time.Date(2026, 3, 11, 0, 0, 0, 0, Location())))
+	Assert(at("next month").Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, Location())))
+	Assert(at("2026-01-02T03:04:05Z").Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	from, to, er := Parse_Time_Range("2026-01..2026-03")
+	Assert(er == nil && from.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, Location())) && to.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, Location())))
+	from, to, er = Parse_Time_Range("-1h..")
+	Assert(er == nil && !from.IsZero() && to.IsZero())
+	from, to, er = Parse_Time_Range("-2h")
+	Assert(er == nil && from.Equal(at("-2h")) && to.IsZero())
+	_, _, er = Parse_Time_Range("2026-03..2026-01")
+	Assert(er == Err_Time_Range)
+	_, er = Parse_Time("someday")
+	Assert(er == Err_Time_Unrecognized)
+}
@@ -0,0 +1,17 @@
+package bone
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_frozen_clock_advances_ok(t *testing.T) {
+	frozen, previous := Freeze_Clock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	defer Set_Clock(previous)
+
+	Assert(Utc() == Utc())
+	start := Utc()
+	Assert(Utc_Sec()*1000 == start)
+	frozen.Advance(1500 * time.Millisecond)
+	Assert(Utc()-start == 1500)
+}
@@ -1,11 +1,11 @@
-package bone
-
-import (
-	"strings"
-
-	"github.com/google/uuid"
-)
-
-func Uuid() string {
-	return strings.ReplaceAll(uuid.New().String(), "-", "")
-}
+package bone
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func Uuid() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
@@ -1,16 +1,113 @@
-package rpc
-
-import (
-	"strconv"
-
-	"github.com/gin-gonic/gin"
-)
-
-func Error(c *gin.Context, e int) {
-	c.Header("code", strconv.Itoa(e))
-}
-
-func Ok(c *gin.Context, body any) {
-	c.Header("code", "0")
-	c.JSON(200, body)
-}
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"seva/lib/bone"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Error_Body struct {
+	Code    int
+	Message string
+	// Set for bone errors, so clients can render the message themselves.
+	Key     string         `json:",omitempty"`
+	Details map[string]any `json:",omitempty"`
+	Cause   string         `json:",omitempty"`
+}
+
+// Responds with an error envelope `{"Error": {"Code", "Message"}}`, the code
+// is also set in the header for clients which do not read the body.
+func Error(c *gin.Context, e int, message string, args ...any) {
+	c.Header("code", strconv.Itoa(e))
+	message = fmt.Sprintf(bone.Tr_In(Locale(c), message), args...)
+	c.Set("error", message)
+	c.AbortWithStatusJSON(400, gin.H{"Error": &Error_Body{
+		Code:    e,
+		Message: message,
+	}})
+}
+
+// Responds with an error envelope built from the error, keeping the code
+// and details of bone errors.
+func Fail(c *gin.Context, er error) {
+	body := &Error_Body{Code: bone.Error_Code(er), Message: er.Error()}
+	var e *bone.Error
+	if errors.As(er, &e) {
+		body.Message = e.Text(Locale(c))
+		body.Key = e.Key
+		body.Details = e.Details
+		if e.Cause != nil {
+			body.Cause = e.Cause.Error()
+		}
+	}
+	c.Header("code", strconv.Itoa(body.Code))
+	c.Set("error", body.Message)
+	c.AbortWithStatusJSON(400, gin.H{"Error": body})
+}
+
+// Locale of the request picked by it's `Accept-Language` header, see
+// `bone.Match_Locale`.
+func Locale(c *gin.Context) string {
+	return bone.Match_Locale(c.GetHeader("Accept-Language"))
+}
+
+func Ok(c *gin.Context, body any) {
+	c.Header("code", "0")
+	c.JSON(200, gin.H{"Body": body})
+}
+
+// Requires `Authorization: Bearer <token>` header on every request. Empty
+// token disables the check. Token is asked for each request, so it can be
+// changed without a restart.
+func Auth(get_token func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := get_token()
+		if token == "" || c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || given != token {
+			c.Header("code", "1")
+			c.AbortWithStatusJSON(401, gin.H{"Error": &Error_Body{
+				Code:    1,
+				Message: "Unauthorized",
+			}})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Logs each request through `bone`, client errors as warnings and server ones
+// as errors.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		log := bone.Log_With(
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"ip", c.ClientIP(),
+		)
+		message, ok := c.Get("error")
+		if ok {
+			log = log.With("error", message)
+		}
+		switch {
+		case status >= 500:
+			log.Error("%s %s", c.Request.Method, c.Request.URL.Path)
+		case status >= 400:
+			log.Warn("%s %s", c.Request.Method, c.Request.URL.Path)
+		default:
+			log.Info("%s %s", c.Request.Method, c.Request.URL.Path)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Guards the terminal between the editor and background tasks.
+var output_lock sync.Mutex
+
+// Prompt with the edited line as it was last drawn, empty if no line is
+// edited at the moment.
+var redraw = ""
+
+// Stop channels of running background tasks by their ids.
+var tasks = map[int]chan struct{}{}
+var tasks_next_id = 0
+var tasks_lock sync.Mutex
+
+// Prints a line from a background task. If a line is being edited, the
+// message is printed above it and the prompt is drawn again.
+func Print_Async(message string, args ...any) {
+	output_lock.Lock()
+	defer output_lock.Unlock()
+	text := fmt.Sprintf(message, args...)
+	if redraw == "" {
+		fmt.Println(text)
+		return
+	}
+	fmt.Printf("\r\033[K%s\n%s", text, redraw)
+}
+
+// Runs the task in background of the interactive shell until Ctrl-C is
+// pressed on an empty line. Outside of the interactive shell it blocks until
+// SIGINT. The task should return once the stop channel is closed.
+func Go(task func(stop <-chan struct{})) {
+	stop := make(chan struct{})
+	if !interactive {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		task(stop)
+		return
+	}
+
+	tasks_lock.Lock()
+	id := tasks_next_id
+	tasks_next_id++
+	tasks[id] = stop
+	tasks_lock.Unlock()
+
+	go func() {
+		task(stop)
+		tasks_lock.Lock()
+		delete(tasks, id)
+		tasks_lock.Unlock()
+	}()
+}
+
+// Stops all background tasks, returns false if none was running.
+func Stop_Tasks() bool {
+	tasks_lock.Lock()
+	defer tasks_lock.Unlock()
+	if len(tasks) == 0 {
+		return false
+	}
+	for id, stop := range tasks {
+		close(stop)
+		delete(tasks, id)
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"seva/lib/bone"
+)
+
+// Seconds a captured confirmation waits for the answer.
+const confirmation_ttl_sec = 300
+
+type Confirmation struct {
+	// Passed to `Confirm_Captured` to answer the question.
+	Token string
+	Text  string
+}
+
+type pending_confirmation struct {
+	ctx         *Command_Context
+	domain      string
+	action      func() int
+	expires_sec int64
+}
+
+// Set by `Confirm` during a captured command, returned in it's result.
+var captured_confirmation *Confirmation = nil
+
+// Set while the interactive shell runs, since only there a prompt can be
+// answered.
+var interactive = false
+
+// Runs the action once the user agrees. Passed `-yes` flag skips the
+// question, and is required outside of the interactive shell. Captured
+// commands return a token instead, which is answered by `Confirm_Captured`.
+func (c *Command_Context) Confirm(text string, action func() int) int {
+	if c.Arg_Bool("-yes", false) {
+		return action()
+	}
+
+	if capturing {
+		now := bone.Utc_Sec()
+		for token, p := range current.confirmations {
+			if p.expires_sec < now {
+				delete(current.confirmations, token)
+			}
+		}
+		token := bone.Uuid()
+		current.confirmations[token] = &pending_confirmation{
+			ctx:         c,
+			domain:      domain,
+			action:      action,
+			expires_sec: now + confirmation_ttl_sec,
+		}
+		captured_confirmation = &Confirmation{Token: token, Text: text}
+		c.Message("%s [Y/N]", text)
+		return OK
+	}
+
+	if !interactive {
+		c.Error("%s Pass -yes to confirm", text)
+		return ERROR
+	}
+	Prompt(text, func(answer bool) int {
+		if !answer {
+			c.Message("Cancelled")
+			return OK
+		}
+		return action()
+	})
+	return OK
+}
+
+// Answers the question of a captured command, running it's action in the
+// domain the command was executed in. Only the session which executed the
+// command knows the token.
+func Confirm_Captured(session_key string, token string, answer bool) *Result {
+	captured_lock.Lock()
+	defer captured_lock.Unlock()
+	out := &capture_writer{items: []*Output_Item{}}
+	previous_domain := domain
+	captured_out = out
+	capturing = true
+	use_session(session_key)
+	defer func() {
+		captured_out = nil
+		capturing = false
+		domain = previous_domain
+		current = local_session
+	}()
+
+	r := &Result{Code: ERROR}
+	p, ok := current.confirmations[token]
+	delete(current.confirmations, token)
+	if !ok || p.expires_sec < bone.Utc_Sec() {
+		r.Domain = domain
+		out.Write(&Output_Item{Kind: OUTPUT_ERROR, Text: "Unknown or expired confirmation"})
+		r.Output = out.items
+		return r
+	}
+
+	domain = p.domain
+	p.ctx.Out = out
+	if answer {
+		r.Code = p.action()
+	} else {
+		p.ctx.Message("Cancelled")
+		r.Code = OK
+	}
+	out.ensure_error(r.Code)
+	r.Domain = domain
+	r.Output = out.items
+	return r
+}
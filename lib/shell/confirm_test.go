@@ -0,0 +1,53 @@
+package shell
+
+import (
+	"seva/lib/bone"
+	"testing"
+	"time"
+)
+
+var confirmed = 0
+
+func init() {
+	Set_Command(&Command{
+		Name: "confirmtest",
+		Args: []*Arg_Spec{{Key: "-yes", Type: ARG_BOOL}},
+		Handler: func(c *Command_Context) int {
+			return c.Confirm("Really?", func() int {
+				confirmed++
+				c.Message("Done")
+				return OK
+			})
+		},
+	})
+}
+
+func Test_confirm_captured_ok(t *testing.T) {
+	confirmed = 0
+	r := Execute_Captured("confirmtest", "", "main", "")
+	bone.Assert(r.Code == OK && r.Confirm != nil && r.Confirm.Text == "Really?")
+	bone.Assert(confirmed == 0)
+
+	token := r.Confirm.Token
+	r = Confirm_Captured("", token, true)
+	bone.Assert(r.Code == OK && confirmed == 1 && r.Output[0].Text == "Done")
+	// Token is answered only once
+	r = Confirm_Captured("", token, true)
+	bone.Assert(r.Code == ERROR && confirmed == 1)
+
+	r = Execute_Captured("confirmtest -yes", "", "main", "")
+	bone.Assert(r.Code == OK && r.Confirm == nil && confirmed == 2)
+}
+
+func Test_confirm_captured_expired(t *testing.T) {
+	confirmed = 0
+	clock, previous := bone.Freeze_Clock(time.Now())
+	defer bone.Set_Clock(previous)
+
+	r := Execute_Captured("confirmtest", "", "main", "")
+	bone.Assert(r.Confirm != nil)
+	clock.Advance((confirmation_ttl_sec + 1) * time.Second)
+	r = Confirm_Captured("", r.Confirm.Token, true)
+	bone.Assert(r.Code == ERROR && r.Output[0].Text == "Unknown or expired confirmation")
+	bone.Assert(confirmed == 0)
+}
@@ -0,0 +1,454 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"seva/lib/bone"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	KEY_CTRL_A    = 1
+	KEY_CTRL_C    = 3
+	KEY_CTRL_D    = 4
+	KEY_CTRL_E    = 5
+	KEY_CTRL_G    = 7
+	KEY_BACKSPACE = 8
+	KEY_TAB       = 9
+	KEY_ENTER     = 13
+	KEY_CTRL_K    = 11
+	KEY_CTRL_L    = 12
+	KEY_CTRL_R    = 18
+	KEY_CTRL_U    = 21
+	KEY_CTRL_W    = 23
+	KEY_ESC       = 27
+	KEY_DELETE    = 127
+)
+
+const history_limit = 1000
+
+// Terminals send escape sequences at once, so a longer pause follows a bare
+// Esc key.
+const escape_timeout_ms = 50
+
+// Returned by `read_line` on Ctrl-C while a question or prompt is pending.
+const read_cancelled = ERROR + 1
+
+type Completion struct {
+	Value string
+	// Shown next to the value when candidates are listed, e.g. a field type.
+	Hint string
+}
+
+// Edits a single line of input in a raw terminal.
+type editor struct {
+	reader *bufio.Reader
+	prompt string
+	buffer []rune
+	cursor int
+
+	history []string
+	// Position while navigating history, equals to the history length for
+	// the line being edited.
+	history_i int
+	// Edited line saved while navigating history.
+	draft []rune
+
+	searching bool
+	query     []rune
+	// History index of the reverse search match, -1 if nothing matches.
+	match_i int
+}
+
+func history_path() string {
+	return bone.Userdir("shell_history")
+}
+
+func (ed *editor) load_history() {
+	data, er := os.ReadFile(history_path())
+	if er != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			ed.history = append(ed.history, line)
+		}
+	}
+	if len(ed.history) > history_limit {
+		ed.history = ed.history[len(ed.history)-history_limit:]
+	}
+}
+
+func (ed *editor) add_history(line string) {
+	if line == "" || strings.Contains(line, "\n") {
+		return
+	}
+	if len(ed.history) > 0 && ed.history[len(ed.history)-1] == line {
+		return
+	}
+	ed.history = append(ed.history, line)
+	f, er := os.OpenFile(history_path(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if er != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
+
+func (ed *editor) refresh() {
+	var line string
+	if ed.searching {
+		match := ""
+		if ed.match_i >= 0 {
+			match = ed.history[ed.match_i]
+		}
+		line = fmt.Sprintf("(reverse-i-search)'%s': %s", string(ed.query), match)
+	} else {
+		line = ed.prompt + string(ed.buffer)
+		tail := len(ed.buffer) - ed.cursor
+		if tail > 0 {
+			line += fmt.Sprintf("\033[%dD", tail)
+		}
+	}
+
+	output_lock.Lock()
+	defer output_lock.Unlock()
+	redraw = line
+	fmt.Print("\r\033[K" + line)
+}
+
+// Moves to the next line, after which the edited line is not redrawn by
+// asynchronous output.
+func (ed *editor) end_line(text string) {
+	output_lock.Lock()
+	defer output_lock.Unlock()
+	redraw = ""
+	fmt.Print(text + "\n")
+}
+
+func (ed *editor) set_buffer(line []rune) {
+	ed.buffer = append([]rune{}, line...)
+	ed.cursor = len(ed.buffer)
+}
+
+func (ed *editor) insert(r rune) {
+	ed.buffer = append(ed.buffer[:ed.cursor], append([]rune{r}, ed.buffer[ed.cursor:]...)...)
+	ed.cursor++
+}
+
+func (ed *editor) insert_string(s string) {
+	for _, r := range s {
+		ed.insert(r)
+	}
+}
+
+func (ed *editor) history_move(delta int) {
+	i := ed.history_i + delta
+	if i < 0 || i > len(ed.history) {
+		return
+	}
+	if ed.history_i == len(ed.history) {
+		ed.draft = ed.buffer
+	}
+	ed.history_i = i
+	if i == len(ed.history) {
+		ed.set_buffer(ed.draft)
+		return
+	}
+	ed.set_buffer([]rune(ed.history[i]))
+}
+
+// Finds the newest history entry containing the query, starting from the
+// index and going back.
+func (ed *editor) search_from(i int) {
+	query := string(ed.query)
+	for ; i >= 0; i-- {
+		if strings.Contains(ed.history[i], query) {
+			ed.match_i = i
+			return
+		}
+	}
+	ed.match_i = -1
+}
+
+func (ed *editor) finish_search(accept bool) {
+	ed.searching = false
+	if accept && ed.match_i >= 0 {
+		ed.set_buffer([]rune(ed.history[ed.match_i]))
+		ed.history_i = ed.match_i
+	}
+}
+
+// Reads the rest of an escape sequence and returns it's final part, e.g. `A`
+// for the up arrow or `3~` for the delete key. Returns an empty string for a
+// bare Esc, after which nothing arrives in `escape_timeout_ms`.
+func (ed *editor) read_escape() string {
+	if ed.reader.Buffered() == 0 && !wait_input(int(os.Stdin.Fd()), escape_timeout_ms) {
+		return ""
+	}
+	r, _, er := ed.reader.ReadRune()
+	if er != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+	seq := ""
+	for {
+		r, _, er = ed.reader.ReadRune()
+		if er != nil {
+			return seq
+		}
+		seq += string(r)
+		if r >= 0x40 && r <= 0x7e {
+			return seq
+		}
+	}
+}
+
+// Returns ERROR on the end of input, and `read_cancelled` if a pending
+// question is cancelled.
+func (ed *editor) read_line(prompt string) (string, int) {
+	restore, e := make_raw(int(os.Stdin.Fd()))
+	if e != OK {
+		return "", ERROR
+	}
+	defer restore()
+
+	ed.prompt = prompt
+	ed.buffer = []rune{}
+	ed.cursor = 0
+	ed.history_i = len(ed.history)
+	ed.searching = false
+	ed.refresh()
+
+	for {
+		r, _, er := ed.reader.ReadRune()
+		if er != nil {
+			ed.end_line("")
+			return "", ERROR
+		}
+
+		if ed.searching {
+			switch r {
+			case KEY_CTRL_R:
+				ed.search_from(ed.match_i - 1)
+			case KEY_DELETE, KEY_BACKSPACE:
+				if len(ed.query) > 0 {
+					ed.query = ed.query[:len(ed.query)-1]
+				}
+				ed.search_from(len(ed.history) - 1)
+			case KEY_CTRL_G, KEY_CTRL_C:
+				ed.finish_search(false)
+			case KEY_ENTER:
+				ed.finish_search(true)
+				ed.refresh()
+				ed.end_line("")
+				return string(ed.buffer), OK
+			case KEY_ESC:
+				ed.read_escape()
+				ed.finish_search(true)
+			default:
+				if unicode.IsPrint(r) {
+					ed.query = append(ed.query, r)
+					ed.search_from(len(ed.history) - 1)
+				} else {
+					ed.finish_search(true)
+				}
+			}
+			ed.refresh()
+			continue
+		}
+
+		switch r {
+		case KEY_ENTER, '\n':
+			ed.end_line("")
+			return string(ed.buffer), OK
+		case KEY_CTRL_C:
+			if current.asked != "" || current.prompted {
+				ed.end_line("^C")
+				return "", read_cancelled
+			}
+			// Ctrl-C on an empty line stops background tasks, otherwise the
+			// line is dropped, as shells usually do.
+			if len(ed.buffer) == 0 && Stop_Tasks() {
+				ed.end_line("^C")
+				ed.end_line("Stopped background tasks")
+				break
+			}
+			ed.end_line("^C")
+			ed.buffer = []rune{}
+			ed.cursor = 0
+			ed.history_i = len(ed.history)
+		case KEY_CTRL_D:
+			if len(ed.buffer) == 0 {
+				ed.end_line("")
+				return "", ERROR
+			}
+			if ed.cursor < len(ed.buffer) {
+				ed.buffer = append(ed.buffer[:ed.cursor], ed.buffer[ed.cursor+1:]...)
+			}
+		case KEY_DELETE, KEY_BACKSPACE:
+			if ed.cursor > 0 {
+				ed.buffer = append(ed.buffer[:ed.cursor-1], ed.buffer[ed.cursor:]...)
+				ed.cursor--
+			}
+		case KEY_CTRL_A:
+			ed.cursor = 0
+		case KEY_CTRL_E:
+			ed.cursor = len(ed.buffer)
+		case KEY_CTRL_K:
+			ed.buffer = ed.buffer[:ed.cursor]
+		case KEY_CTRL_U:
+			ed.buffer = ed.buffer[ed.cursor:]
+			ed.cursor = 0
+		case KEY_CTRL_W:
+			start := ed.cursor
+			for start > 0 && unicode.IsSpace(ed.buffer[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(ed.buffer[start-1]) {
+				start--
+			}
+			ed.buffer = append(ed.buffer[:start], ed.buffer[ed.cursor:]...)
+			ed.cursor = start
+		case KEY_CTRL_L:
+			fmt.Print("\033[H\033[2J")
+		case KEY_CTRL_R:
+			ed.searching = true
+			ed.query = []rune{}
+			ed.match_i = -1
+		case KEY_TAB:
+			ed.complete()
+		case KEY_ESC:
+			switch ed.read_escape() {
+			case "A":
+				ed.history_move(-1)
+			case "B":
+				ed.history_move(1)
+			case "C":
+				if ed.cursor < len(ed.buffer) {
+					ed.cursor++
+				}
+			case "D":
+				if ed.cursor > 0 {
+					ed.cursor--
+				}
+			case "H", "1~":
+				ed.cursor = 0
+			case "F", "4~":
+				ed.cursor = len(ed.buffer)
+			case "3~":
+				if ed.cursor < len(ed.buffer) {
+					ed.buffer = append(ed.buffer[:ed.cursor], ed.buffer[ed.cursor+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				ed.insert(r)
+			}
+		}
+		ed.refresh()
+	}
+}
+
+// Returns candidates for the token under the cursor, and the token's prefix
+// already typed.
+func completions(line string) ([]Completion, string) {
+	parsed, problem := tokenize(line)
+	if problem != "" {
+		return nil, ""
+	}
+	tokens := token_texts(parsed)
+	if line == "" || unicode.IsSpace(rune(line[len(line)-1])) {
+		tokens = append(tokens, "")
+	}
+	prefix := tokens[len(tokens)-1]
+
+	candidates := []Completion{}
+	if len(tokens) == 1 {
+		for _, cmd := range Get_Commands() {
+			candidates = append(candidates, Completion{Value: cmd.Name, Hint: cmd.Description})
+			for _, alias := range cmd.Aliases {
+				candidates = append(candidates, Completion{Value: alias, Hint: cmd.Name})
+			}
+		}
+	} else {
+		cmd := Get_Command(tokens[0])
+		if cmd == nil {
+			return nil, prefix
+		}
+		if strings.HasPrefix(prefix, "-") {
+			candidates = append(candidates, Completion{Value: "-o", Hint: "Output format: human, json or csv."})
+			for _, spec := range cmd.Args {
+				if spec.Key != "_" {
+					candidates = append(candidates, Completion{Value: spec.Key, Hint: spec.Description})
+				}
+			}
+		} else if cmd.Complete != nil {
+			candidates = cmd.Complete(tokens[1:])
+		}
+	}
+
+	r := []Completion{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c.Value, prefix) {
+			r = append(r, c)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Value < r[j].Value
+	})
+	return r, prefix
+}
+
+// Compared by runes, so a multibyte character is never split.
+func common_prefix(candidates []Completion) string {
+	prefix := []rune(candidates[0].Value)
+	for _, c := range candidates[1:] {
+		value := []rune(c.Value)
+		n := 0
+		for n < len(prefix) && n < len(value) && prefix[n] == value[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+func (ed *editor) complete() {
+	if current.asked != "" {
+		return
+	}
+	candidates, prefix := completions(string(ed.buffer[:ed.cursor]))
+	if len(candidates) == 0 {
+		return
+	}
+	if len(candidates) == 1 {
+		value := candidates[0].Value
+		ed.insert_string(strings.TrimPrefix(value, prefix))
+		// Values like `key=` expect continuation right away.
+		if !strings.HasSuffix(value, "=") {
+			ed.insert(' ')
+		}
+		return
+	}
+
+	common := common_prefix(candidates)
+	if len(common) > len(prefix) {
+		ed.insert_string(strings.TrimPrefix(common, prefix))
+		return
+	}
+
+	ed.end_line("")
+	width := 0
+	for _, c := range candidates {
+		if utf8.RuneCountInString(c.Value) > width {
+			width = utf8.RuneCountInString(c.Value)
+		}
+	}
+	for _, c := range candidates {
+		fmt.Printf("%s%s  %s\n", c.Value, strings.Repeat(" ", width-utf8.RuneCountInString(c.Value)), c.Hint)
+	}
+}
@@ -0,0 +1,12 @@
+package shell
+
+import (
+	"seva/lib/bone"
+	"testing"
+)
+
+func Test_common_prefix_ok(t *testing.T) {
+	bone.Assert(common_prefix([]Completion{{Value: "status=оплачен"}, {Value: "status=отменён"}}) == "status=о")
+	bone.Assert(common_prefix([]Completion{{Value: "ab"}, {Value: "cd"}}) == "")
+	bone.Assert(common_prefix([]Completion{{Value: "domain"}}) == "domain")
+}
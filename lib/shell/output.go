@@ -0,0 +1,224 @@
+package shell
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"seva/lib/bone"
+	"strings"
+)
+
+// Kinds of output items.
+const (
+	OUTPUT_MESSAGE = "message"
+	OUTPUT_ERROR   = "error"
+	OUTPUT_TABLE   = "table"
+	// Single row, its headers are keys.
+	OUTPUT_RECORD = "record"
+)
+
+// Formats accepted by the `-o` flag of every command.
+const (
+	FORMAT_HUMAN = "human"
+	FORMAT_JSON  = "json"
+	FORMAT_CSV   = "csv"
+)
+
+type Output_Item struct {
+	Kind string
+	Text string `json:",omitempty"`
+	// Code of a failed operation for error items, see `Fail`.
+	Code    int        `json:",omitempty"`
+	Headers []string   `json:",omitempty"`
+	Rows    [][]string `json:",omitempty"`
+}
+
+// Receives results of a command, so handlers do not depend on how and where
+// they are shown.
+type Writer interface {
+	Write(item *Output_Item)
+	// Called once the command is finished.
+	Flush()
+}
+
+func new_writer(format string, locale string) Writer {
+	switch format {
+	case FORMAT_HUMAN:
+		return &human_writer{locale: locale}
+	case FORMAT_JSON:
+		return &json_writer{items: []*Output_Item{}}
+	case FORMAT_CSV:
+		return &csv_writer{}
+	}
+	return nil
+}
+
+// Prints results right away, tables are numbered for `@N` references.
+// Headers are translated into the locale, other writers keep them as stable
+// keys for scripts.
+type human_writer struct {
+	locale string
+}
+
+func (w *human_writer) Write(item *Output_Item) {
+	switch item.Kind {
+	case OUTPUT_MESSAGE:
+		bone.Log("%s", item.Text)
+	case OUTPUT_ERROR:
+		bone.Log_User_Error("%s", item.Text)
+	case OUTPUT_TABLE:
+		headers := make([]string, len(item.Headers))
+		for i, header := range item.Headers {
+			headers[i] = bone.Tr_In(w.locale, header)
+		}
+		Print_Table(headers, item.Rows)
+	case OUTPUT_RECORD:
+		Print_Record(item.Headers, item.Rows[0])
+	}
+}
+
+func (w *human_writer) Flush() {}
+
+// Prints all items of the command as a single JSON array.
+type json_writer struct {
+	items []*Output_Item
+}
+
+func (w *json_writer) Write(item *Output_Item) {
+	w.items = append(w.items, item)
+}
+
+func (w *json_writer) Flush() {
+	data, er := json.MarshalIndent(w.items, "", "  ")
+	if er != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Cannot encode output, error: %s\n", er)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// Prints tables with their headers and records as `key,value` lines.
+// Messages and errors go to stderr, so stdout stays valid CSV.
+type csv_writer struct{}
+
+func (w *csv_writer) Write(item *Output_Item) {
+	out := csv.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch item.Kind {
+	case OUTPUT_MESSAGE:
+		fmt.Fprintln(os.Stderr, item.Text)
+	case OUTPUT_ERROR:
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", item.Text)
+	case OUTPUT_TABLE:
+		out.Write(item.Headers)
+		out.WriteAll(item.Rows)
+	case OUTPUT_RECORD:
+		for i, key := range item.Headers {
+			out.Write([]string{key, item.Rows[0][i]})
+		}
+	}
+}
+
+func (w *csv_writer) Flush() {}
+
+// Collects items, e.g. to be returned over RPC.
+type capture_writer struct {
+	items []*Output_Item
+}
+
+func (w *capture_writer) Write(item *Output_Item) {
+	w.items = append(w.items, item)
+}
+
+func (w *capture_writer) Flush() {}
+
+// Failures logged by storage functions stay in the server log, so a failed
+// command without an error item gets a generic one.
+func (w *capture_writer) ensure_error(code int) {
+	if code == OK {
+		return
+	}
+	for _, item := range w.items {
+		if item.Kind == OUTPUT_ERROR {
+			return
+		}
+	}
+	w.Write(&Output_Item{Kind: OUTPUT_ERROR, Text: "Command failed, see the server log", Code: code})
+}
+
+// Translates the message into the locale of the command, the English text
+// serves as the key.
+func (c *Command_Context) Message(message string, args ...any) {
+	c.Out.Write(&Output_Item{Kind: OUTPUT_MESSAGE, Text: fmt.Sprintf(bone.Tr_In(c.Locale, message), args...)})
+}
+
+// Picks the plural form of the message for the amount, see `bone.Tr_Plural`.
+func (c *Command_Context) Message_Plural(n int, message string, args ...any) {
+	c.Out.Write(&Output_Item{Kind: OUTPUT_MESSAGE, Text: bone.Tr_Plural_In(c.Locale, message, n, args...)})
+}
+
+func (c *Command_Context) Error(message string, args ...any) {
+	c.Out.Write(&Output_Item{Kind: OUTPUT_ERROR, Text: fmt.Sprintf(bone.Tr_In(c.Locale, message), args...)})
+}
+
+// Reports the error with it's code, and returns ERROR for the handler to
+// return.
+func (c *Command_Context) Fail(er error) int {
+	text := er.Error()
+	var e *bone.Error
+	if errors.As(er, &e) {
+		text = e.Text(c.Locale)
+	}
+	c.Out.Write(&Output_Item{Kind: OUTPUT_ERROR, Text: text, Code: bone.Error_Code(er)})
+	return ERROR
+}
+
+// Headers are English keys, human output translates them.
+func (c *Command_Context) Table(headers []string, rows [][]string) {
+	c.Out.Write(&Output_Item{Kind: OUTPUT_TABLE, Headers: headers, Rows: rows})
+}
+
+// Keys are field names, so they are kept as is.
+func (c *Command_Context) Record(keys []string, values []string) {
+	c.Out.Write(&Output_Item{Kind: OUTPUT_RECORD, Headers: keys, Rows: [][]string{values}})
+}
+
+// Writer of the running captured command, receives errors reported by the
+// shell itself, see `report_error`.
+var captured_out *capture_writer = nil
+
+// Reports an error of the shell, like an unknown command, to the captured
+// command or to the user.
+func report_error(message string, args ...any) {
+	if captured_out != nil {
+		captured_out.Write(&Output_Item{Kind: OUTPUT_ERROR, Text: fmt.Sprintf(message, args...)})
+		return
+	}
+	bone.Log_User_Error(message, args...)
+}
+
+// Routes messages logged outside of handlers, e.g. by storage functions, to
+// the writer. Human output is left to `bone.Log` itself. Captured commands
+// run in the server along with other goroutines, so their writer gets only
+// the command's own output, and the log stays process-wide.
+func redirect_log(out Writer) func() {
+	switch out.(type) {
+	case *human_writer, *capture_writer:
+		return func() {}
+	}
+	previous := bone.Log_Redirect
+	bone.Log_Redirect = func(is_error bool, message string) {
+		kind := OUTPUT_MESSAGE
+		if is_error {
+			kind = OUTPUT_ERROR
+		}
+		for _, line := range strings.Split(message, "\n") {
+			out.Write(&Output_Item{Kind: kind, Text: line})
+		}
+	}
+	return func() {
+		bone.Log_Redirect = previous
+	}
+}
@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"seva/lib/bone"
+	"testing"
+)
+
+// Returns what the function prints to stdout.
+func capture_stdout(fn func()) string {
+	return capture_file(&os.Stdout, fn)
+}
+
+func capture_stderr(fn func()) string {
+	return capture_file(&os.Stderr, fn)
+}
+
+func capture_file(file **os.File, fn func()) string {
+	r, w, er := os.Pipe()
+	bone.Assert(er == nil)
+	previous := *file
+	*file = w
+	fn()
+	*file = previous
+	w.Close()
+	data, er := io.ReadAll(r)
+	bone.Assert(er == nil)
+	return string(data)
+}
+
+// Writes a message, a table and a record with the format.
+func write_items(format string) string {
+	return capture_stdout(func() {
+		c := Command_Context{Out: new_writer(format, "")}
+		c.Message("Listed")
+		c.Table([]string{"SEQ", "FIELDS"}, [][]string{{"1", `a="x, y"`}, {"2", ""}})
+		c.Record([]string{"seq", "type"}, []string{"1", "ORDER"})
+		c.Out.Flush()
+	})
+}
+
+func Test_json_writer_ok(t *testing.T) {
+	items := []*Output_Item{}
+	bone.Assert(json.Unmarshal([]byte(write_items(FORMAT_JSON)), &items) == nil)
+	bone.Assert(len(items) == 3)
+	bone.Assert(items[0].Kind == OUTPUT_MESSAGE && items[0].Text == "Listed")
+	bone.Assert(items[1].Kind == OUTPUT_TABLE && items[1].Headers[0] == "SEQ" && items[1].Rows[0][1] == `a="x, y"`)
+	bone.Assert(items[2].Kind == OUTPUT_RECORD && items[2].Rows[0][1] == "ORDER")
+}
+
+func Test_csv_writer_ok(t *testing.T) {
+	// Messages go to stderr, so stdout holds only the data
+	bone.Assert(write_items(FORMAT_CSV) == "SEQ,FIELDS\n1,\"a=\"\"x, y\"\"\"\n2,\nseq,1\ntype,ORDER\n")
+}
@@ -0,0 +1,226 @@
+package shell
+
+import (
+	"fmt"
+	"seva/lib/bone"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of argument values.
+const (
+	ARG_STRING = "string"
+	ARG_INT    = "int"
+	ARG_FLOAT  = "float"
+	// Flag without a value, it's presence means true.
+	ARG_BOOL = "bool"
+)
+
+type Arg_Spec struct {
+	// Flag key like `-n`, or `_` for the main input of the command.
+	Key string
+	// Placeholder of the value shown in usage, e.g. `TYPE`.
+	Name     string
+	Type     string
+	Required bool
+	// Accepts many tokens, e.g. `key=value` pairs.
+	Variadic    bool
+	Description string
+}
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Args        []*Arg_Spec
+	Handler     Command_Handler `json:"-"`
+	// Returns candidates for the last of the tokens following the command
+	// name. Flags are completed from the arguments schema.
+	Complete func(tokens []string) []Completion `json:"-"`
+}
+
+// Commands by their names
+var commands = map[string]*Command{}
+
+// Command names by their aliases
+var aliases = map[string]string{}
+
+func Set_Command(cmd *Command) {
+	bone.Assert(cmd.Handler != nil, "Command '%s' has no handler", cmd.Name)
+	commands[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		aliases[alias] = cmd.Name
+	}
+}
+
+// Returns command by it's name or alias.
+func Get_Command(name string) *Command {
+	cmd, ok := commands[name]
+	if ok {
+		return cmd
+	}
+	name, ok = aliases[name]
+	if ok {
+		return commands[name]
+	}
+	return nil
+}
+
+// Returns all registered commands sorted by names.
+func Get_Commands() []*Command {
+	r := make([]*Command, 0, len(commands))
+	for _, cmd := range commands {
+		r = append(r, cmd)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+func (spec *Arg_Spec) usage() string {
+	name := spec.Name
+	if name == "" {
+		name = strings.ToUpper(spec.Type)
+	}
+	if spec.Variadic {
+		name += "..."
+	}
+	var r string
+	switch {
+	case spec.Key == "_":
+		r = name
+	case spec.Type == ARG_BOOL:
+		r = spec.Key
+	default:
+		r = spec.Key + " " + name
+	}
+	if !spec.Required {
+		r = "[" + r + "]"
+	}
+	return r
+}
+
+func (cmd *Command) Usage() string {
+	parts := []string{cmd.Name}
+	for _, spec := range cmd.Args {
+		parts = append(parts, spec.usage())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (cmd *Command) find_arg(key string) *Arg_Spec {
+	for _, spec := range cmd.Args {
+		if spec.Key == key {
+			return spec
+		}
+	}
+	return nil
+}
+
+// Checks parsed arguments against the command's schema, so handlers receive
+// only declared arguments of correct types.
+func (c *Command_Context) validate(cmd *Command) int {
+	for key, tokens := range c.args {
+		spec := cmd.find_arg(key)
+		if spec == nil {
+			if key == "_" && len(tokens) == 0 {
+				continue
+			}
+			if key == "_" {
+				c.Error("Command '%s' does not accept input", cmd.Name)
+				return ERROR
+			}
+			c.Error("Unknown argument '%s' for command '%s'", key, cmd.Name)
+			return ERROR
+		}
+		if key == "_" && len(tokens) == 0 {
+			continue
+		}
+
+		if spec.Type == ARG_BOOL {
+			if len(tokens) > 0 {
+				c.Error("Flag '%s' does not accept a value", key)
+				return ERROR
+			}
+			continue
+		}
+		if len(tokens) == 0 {
+			c.Error("Argument '%s' requires a value", key)
+			return ERROR
+		}
+		if len(tokens) > 1 && !spec.Variadic {
+			c.Error("Argument '%s' accepts a single value, got %d", key, len(tokens))
+			return ERROR
+		}
+		for _, token := range tokens {
+			switch spec.Type {
+			case ARG_INT:
+				_, er := strconv.Atoi(token)
+				if er != nil {
+					c.Error("Argument '%s' expects an integer, got '%s'", key, token)
+					return ERROR
+				}
+			case ARG_FLOAT:
+				_, er := strconv.ParseFloat(token, 64)
+				if er != nil {
+					c.Error("Argument '%s' expects a float, got '%s'", key, token)
+					return ERROR
+				}
+			}
+		}
+	}
+
+	for _, spec := range cmd.Args {
+		if !spec.Required {
+			continue
+		}
+		tokens, ok := c.args[spec.Key]
+		if !ok || (spec.Type != ARG_BOOL && len(tokens) == 0) {
+			c.Error("Missing required argument '%s', usage: %s", spec.usage(), cmd.Usage())
+			return ERROR
+		}
+	}
+	return OK
+}
+
+func help(c *Command_Context) int {
+	name := c.Arg_String("_", "")
+	if name == "" {
+		cmds := Get_Commands()
+		rows := make([][]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			rows = append(rows, []string{cmd.Name, strings.Join(cmd.Aliases, ","), cmd.Description})
+		}
+		c.Table([]string{"COMMAND", "ALIASES", "DESCRIPTION"}, rows)
+		return OK
+	}
+
+	cmd := Get_Command(name)
+	if cmd == nil {
+		c.Error("Unrecognized command: %s", name)
+		return ERROR
+	}
+	c.Message("Usage: %s", cmd.Usage())
+	if len(cmd.Aliases) > 0 {
+		c.Message("Aliases: %s", strings.Join(cmd.Aliases, ", "))
+	}
+	if cmd.Description != "" {
+		c.Message("%s", cmd.Description)
+	}
+	if len(cmd.Args) > 0 {
+		keys := make([]string, 0, len(cmd.Args))
+		values := make([]string, 0, len(cmd.Args))
+		for _, spec := range cmd.Args {
+			keys = append(keys, "  "+spec.usage())
+			description := spec.Description
+			if spec.Required {
+				description = fmt.Sprintf("%s (required)", description)
+			}
+			values = append(values, description)
+		}
+		c.Record(keys, values)
+	}
+	return OK
+}
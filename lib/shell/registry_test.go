@@ -0,0 +1,53 @@
+package shell
+
+import (
+	"seva/lib/bone"
+	"testing"
+)
+
+var test_command = &Command{
+	Name: "addevent",
+	Args: []*Arg_Spec{
+		{Key: "_", Name: "TYPE key=value", Type: ARG_STRING, Required: true, Variadic: true},
+		{Key: "-n", Type: ARG_INT},
+		{Key: "-f", Type: ARG_FLOAT},
+		{Key: "-i", Type: ARG_BOOL},
+	},
+	Handler: func(c *Command_Context) int { return OK },
+}
+
+// Returns the result of validating the tokens and the reported error.
+func validate_tokens(tokens ...string) (int, string) {
+	out := &capture_writer{}
+	c := Command_Context{Out: out}
+	c.parse(test_tokens(tokens...))
+	e := c.validate(test_command)
+	if len(out.items) == 0 {
+		return e, ""
+	}
+	return e, out.items[0].Text
+}
+
+func Test_validate_ok(t *testing.T) {
+	e, _ := validate_tokens("ORDER", "id=1", "-n", "-5", "-f", "1.5", "-i")
+	bone.Assert(e == OK)
+	bone.Assert(test_command.Usage() == "addevent TYPE key=value... [-n INT] [-f FLOAT] [-i]")
+}
+
+func Test_validate_error(t *testing.T) {
+	var e int
+	var text string
+
+	e, text = validate_tokens("-n", "1")
+	bone.Assert(e == ERROR && text == "Missing required argument 'TYPE key=value...', usage: "+test_command.Usage())
+	e, text = validate_tokens("ORDER", "-x")
+	bone.Assert(e == ERROR && text == "Unknown argument '-x' for command 'addevent'")
+	e, text = validate_tokens("ORDER", "-n", "one")
+	bone.Assert(e == ERROR && text == "Argument '-n' expects an integer, got 'one'")
+	e, text = validate_tokens("ORDER", "-n", "1", "2")
+	bone.Assert(e == ERROR && text == "Argument '-n' accepts a single value, got 2")
+	e, text = validate_tokens("ORDER", "-f")
+	bone.Assert(e == ERROR && text == "Argument '-f' requires a value")
+	e, text = validate_tokens("ORDER", "-i", "yes")
+	bone.Assert(e == ERROR && text == "Flag '-i' does not accept a value")
+}
@@ -0,0 +1,60 @@
+package shell
+
+import (
+	"seva/lib/bone"
+)
+
+// State a command leaves for the next input of the same user: rows of the
+// last listing referred as `@N`, a pending question and confirmations. The
+// interactive shell has a session of it's own, captured commands pass the key
+// of theirs, e.g. one per web terminal.
+type session struct {
+	hooks             []any
+	prompted          bool
+	prompted_callback func(answer bool) int
+	// Question of `Ask` shown instead of the prompt, empty if nothing is
+	// asked.
+	asked          string
+	asked_callback func(answer string) int
+	// Pending confirmations of captured commands by their tokens.
+	confirmations map[string]*pending_confirmation
+	used_sec      int64
+}
+
+// Seconds an unused session of captured commands is kept.
+const session_ttl_sec = 3600
+
+var local_session = new_session()
+
+// Session of the running command, the local one outside of captured
+// commands.
+var current = local_session
+
+// Sessions of captured commands by their keys.
+var sessions = map[string]*session{}
+
+func new_session() *session {
+	return &session{
+		hooks:         []any{},
+		confirmations: map[string]*pending_confirmation{},
+	}
+}
+
+// Makes the session of the key current, creating it on the first use.
+// Sessions unused for `session_ttl_sec` are dropped along with their
+// confirmations.
+func use_session(key string) {
+	now := bone.Utc_Sec()
+	for k, s := range sessions {
+		if s.used_sec+session_ttl_sec < now {
+			delete(sessions, k)
+		}
+	}
+	s, ok := sessions[key]
+	if !ok {
+		s = new_session()
+		sessions[key] = s
+	}
+	s.used_sec = now
+	current = s
+}
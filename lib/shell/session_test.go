@@ -0,0 +1,93 @@
+package shell
+
+import (
+	"fmt"
+	"seva/lib/bone"
+	"sync"
+	"testing"
+	"time"
+)
+
+var answered = ""
+
+func init() {
+	Set_Command(&Command{
+		Name: "hooktest",
+		Args: []*Arg_Spec{{Key: "_", Type: ARG_STRING}},
+		Handler: func(c *Command_Context) int {
+			arg := c.Arg_String("_", "")
+			if arg == "@1" {
+				item, ok := Resolve_Hook(arg)
+				if !ok {
+					c.Error("No hook")
+					return ERROR
+				}
+				c.Message("%s", item)
+				return OK
+			}
+			Set_Hooks([]string{arg})
+			return OK
+		},
+	})
+	Set_Command(&Command{
+		Name: "asktest",
+		Handler: func(c *Command_Context) int {
+			Ask("Name?", func(answer string) int {
+				answered = answer
+				return OK
+			})
+			return OK
+		},
+	})
+}
+
+func Test_session_captured_ok(t *testing.T) {
+	// Both sessions list and refer rows at the same time
+	wg := sync.WaitGroup{}
+	for _, key := range []string{"first", "second"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				value := fmt.Sprintf("%s-%d", key, i)
+				bone.Assert(Execute_Captured("hooktest "+value, key, "main", "").Code == OK)
+				r := Execute_Captured("hooktest @1", key, "main", "")
+				bone.Assert(r.Code == OK && r.Output[0].Text == value)
+			}
+		}()
+	}
+	wg.Wait()
+	// Hooks of captured sessions are not left for the local one
+	_, ok := Resolve_Hook("@1")
+	bone.Assert(!ok)
+
+	// Question is answered by the next input of the same session only
+	answered = ""
+	bone.Assert(Execute_Captured("asktest", "first", "main", "").Code == OK)
+	r := Execute_Captured("hooktest @1", "second", "main", "")
+	bone.Assert(r.Code == OK && r.Output[0].Text == "second-99" && answered == "")
+	bone.Assert(Execute_Captured("Ann", "first", "main", "").Code == OK)
+	bone.Assert(answered == "Ann")
+}
+
+func Test_session_confirm_ok(t *testing.T) {
+	confirmed = 0
+	r := Execute_Captured("confirmtest", "first", "main", "")
+	bone.Assert(r.Confirm != nil)
+
+	// Other sessions do not know the token
+	r2 := Confirm_Captured("second", r.Confirm.Token, true)
+	bone.Assert(r2.Code == ERROR && confirmed == 0)
+	r2 = Confirm_Captured("first", r.Confirm.Token, true)
+	bone.Assert(r2.Code == OK && confirmed == 1)
+}
+
+func Test_session_expired(t *testing.T) {
+	clock, previous := bone.Freeze_Clock(bone.Now())
+	defer bone.Set_Clock(previous)
+
+	bone.Assert(Execute_Captured("hooktest kept", "first", "main", "").Code == OK)
+	clock.Advance((session_ttl_sec + 1) * time.Second)
+	r := Execute_Captured("hooktest @1", "first", "main", "")
+	bone.Assert(r.Code == ERROR)
+}
@@ -1,270 +1,505 @@
-// Organizes interactive shell.
-package shell
-
-import (
-	"bufio"
-	"fmt"
-	"os"
-	"regexp"
-	"seva/lib/bone"
-	"strconv"
-	"strings"
-)
-
-type Command_Handler func(ctx *Command_Context) int
-
-var commands = map[string]Command_Handler{}
-var domain string = ""
-
-const (
-	OK = iota
-	ERROR
-)
-
-var hooks = []any{}
-var prompted = false
-var prompted_callback func(answer bool) int = nil
-
-type Command_Context struct {
-	Raw_Input    string
-	Command_Name string
-	args         map[string]string
-}
-
-func (c *Command_Context) parse(raw_args []string) {
-	c.args = map[string]string{}
-
-	prev_arg := ""
-	// Collect buffer until the next argument
-	buffer := ""
-	for _, a := range raw_args {
-		if strings.HasPrefix(a, "-") {
-			if prev_arg != "" {
-				// Assign buffer even if it's empty (e.g. for flag arguments)
-				c.args[prev_arg] = buffer
-			} else if buffer != "" {
-				// If previous argument were not defined, it means we're collecting
-				// input to the main command - in this case we save it under `_` key.
-				// Same operation should be done both before next argument or before end
-				// of the arguments.
-				c.args["_"] = buffer
-			}
-			prev_arg = a
-			buffer = ""
-			continue
-		}
-		if buffer != "" {
-			buffer += " "
-		}
-		buffer += a
-	}
-
-	// End of input, assign even empty buffer
-	if prev_arg != "" {
-		c.args[prev_arg] = buffer
-	} else {
-		c.args["_"] = buffer
-	}
-}
-
-// IF BUFFER IS EMPTY RETURN DEFAULT
-func (c *Command_Context) Arg_String(key string, default_ string) string {
-	if !strings.HasPrefix(key, "-") && key != "_" {
-		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
-		return default_
-	}
-
-	buffer, ok := c.args[key]
-	if !ok {
-		return default_
-	}
-	if buffer == "" {
-		return default_
-	}
-	return buffer
-}
-
-// Returns true if key exists.
-func (c *Command_Context) Arg_Bool(key string, default_ bool) bool {
-	if !strings.HasPrefix(key, "-") && key != "_" {
-		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
-		return default_
-	}
-
-	_, ok := c.args[key]
-	if !ok {
-		return default_
-	}
-	return true
-}
-
-func (c *Command_Context) Arg_Int(key string, default_ int) int {
-	if !strings.HasPrefix(key, "-") && key != "_" {
-		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
-		return default_
-	}
-
-	buffer, ok := c.args[key]
-	if !ok {
-		return default_
-	}
-	r, er := strconv.Atoi(buffer)
-	if er != nil {
-		bone.Log_Error("Unable to convert argument '%s' value '%s' to integer", key, buffer)
-		return default_
-	}
-	return r
-}
-
-func (c *Command_Context) Arg_Float(key string, default_ float64) float64 {
-	if !strings.HasPrefix(key, "-") && key != "_" {
-		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
-		return default_
-	}
-
-	buffer, ok := c.args[key]
-	if !ok {
-		return default_
-	}
-	r, er := strconv.ParseFloat(buffer, 64)
-	if er != nil {
-		bone.Log_Error("Unable to convert argument '%s' value '%s' to float", key, buffer)
-		return default_
-	}
-	return r
-}
-
-func Get_Hook(i int) any {
-	if i >= len(hooks) {
-		return nil
-	}
-	return hooks[i]
-}
-
-func Set_Hooks[T any](items []T) {
-	Clear_Hooks()
-	for _, i := range items {
-		hooks = append(hooks, i)
-	}
-}
-
-func Clear_Hooks() {
-	hooks = []any{}
-}
-
-func Answer_Prompt(answer bool) {
-	if !prompted {
-		bone.Log_Error("Inactive prompt")
-		return
-	}
-	prompted = false
-	e := prompted_callback(answer)
-	if e != OK {
-		bone.Log_Error("During prompted callback, an error #%d occured", e)
-	}
-	prompted_callback = nil
-}
-
-func Prompt(text string, callback func(answer bool) int) {
-	if prompted {
-		bone.Log_Error("Already prompted")
-		return
-	}
-	prompted = true
-	prompted_callback = callback
-	bone.Log(text + " [Y/N]")
-}
-
-func process_input(input string) {
-	// Quoted strings are not yet supported - they will be separated as everything else.
-	input_parts := strings.Fields(input)
-	if len(input_parts) == 0 {
-		return
-	}
-
-	if prompted {
-		var answer bool
-		switch input {
-		case "y":
-			answer = true
-		case "n":
-			answer = false
-		case "Y":
-			answer = true
-		case "N":
-			answer = false
-		default:
-			bone.Log("Type answer 'Y' or 'N'")
-			return
-		}
-		Answer_Prompt(answer)
-		return
-	}
-
-	command_name := input_parts[0]
-
-	cmd, ok := commands[command_name]
-	if !ok {
-		bone.Log_Error("Unrecognized command: " + input)
-		return
-	}
-
-	raw_args := []string{}
-	if len(input_parts) > 1 {
-		raw_args = input_parts[1:]
-	}
-	ctx := Command_Context{
-		Raw_Input:    input,
-		Command_Name: command_name,
-	}
-	ctx.parse(raw_args)
-
-	cmd(&ctx)
-}
-
-func Init() {
-}
-
-func Set_Command(key string, handler Command_Handler) {
-	commands[key] = handler
-}
-
-func Run() {
-	console_reader := bufio.NewReader(os.Stdin)
-
-	// Main loop is blocking on input, other background tasks are goroutines.
-	for {
-		var final_sign = ">"
-		if prompted {
-			final_sign = "?"
-		}
-		fmt.Printf("\033[33m(%s)\033[0m\033[35m%s\033[0m ", domain, final_sign)
-		input, er := console_reader.ReadString('\n')
-		if er != nil {
-			if er.Error() != "EOF" {
-				bone.Log_Error("Unexpected error occured while reading console: %s", er)
-			}
-			return
-		}
-		input = strings.TrimSpace(input)
-		if input == "q" {
-			return
-		}
-		process_input(input)
-	}
-}
-
-var domain_regex = regexp.MustCompile("^[a-z0-9_]*$")
-
-func Get_Domain() string {
-	return domain
-}
-
-func Set_Domain(d string) int {
-	if !domain_regex.MatchString(d) {
-		bone.Log_Error("Incorrect domain '%s'", d)
-		return ERROR
-	}
-	domain = d
-	return OK
-}
+// Organizes interactive shell.
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"seva/lib/bone"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Command_Handler func(ctx *Command_Context) int
+
+var domain string = ""
+
+const (
+	OK = iota
+	ERROR
+)
+
+type Command_Context struct {
+	Raw_Input    string
+	Command_Name string
+	// Receives results of the command, see `Message`, `Table` and others.
+	Out Writer
+	// Locale messages of the command are translated into, see `bone.Tr_In`.
+	Locale string
+	// Tokens by their argument keys.
+	args map[string][]string
+}
+
+var flag_regex = regexp.MustCompile("^-[a-zA-Z]")
+
+// Token is considered a flag if it starts with a dash followed by a letter,
+// so negative numbers can be passed as values.
+func is_flag(token string) bool {
+	return flag_regex.MatchString(token)
+}
+
+func (c *Command_Context) parse(raw_args []Token) {
+	c.args = map[string][]string{}
+
+	// If a flag is not yet met, we're collecting input to the main command -
+	// in this case we save it under `_` key. Flags are assigned even with
+	// empty tokens list.
+	key := "_"
+	tokens := []string{}
+	for _, a := range raw_args {
+		if !a.Quoted && is_flag(a.Text) {
+			if key != "_" || len(tokens) > 0 {
+				c.args[key] = tokens
+			}
+			key = a.Text
+			tokens = []string{}
+			continue
+		}
+		tokens = append(tokens, a.Text)
+	}
+
+	// End of input, assign even empty tokens
+	c.args[key] = tokens
+}
+
+// Returns tokens of an argument, as they were separated in the input.
+func (c *Command_Context) Arg_List(key string) []string {
+	if !strings.HasPrefix(key, "-") && key != "_" {
+		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
+		return []string{}
+	}
+
+	tokens, ok := c.args[key]
+	if !ok {
+		return []string{}
+	}
+	return tokens
+}
+
+// IF BUFFER IS EMPTY RETURN DEFAULT
+func (c *Command_Context) Arg_String(key string, default_ string) string {
+	if !strings.HasPrefix(key, "-") && key != "_" {
+		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
+		return default_
+	}
+
+	tokens, ok := c.args[key]
+	if !ok {
+		return default_
+	}
+	buffer := strings.Join(tokens, " ")
+	if buffer == "" {
+		return default_
+	}
+	return buffer
+}
+
+// Returns true if key exists.
+func (c *Command_Context) Arg_Bool(key string, default_ bool) bool {
+	if !strings.HasPrefix(key, "-") && key != "_" {
+		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
+		return default_
+	}
+
+	_, ok := c.args[key]
+	if !ok {
+		return default_
+	}
+	return true
+}
+
+func (c *Command_Context) Arg_Int(key string, default_ int) int {
+	if !strings.HasPrefix(key, "-") && key != "_" {
+		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
+		return default_
+	}
+
+	tokens, ok := c.args[key]
+	if !ok {
+		return default_
+	}
+	buffer := strings.Join(tokens, " ")
+	r, er := strconv.Atoi(buffer)
+	if er != nil {
+		report_error("Unable to convert argument '%s' value '%s' to integer", key, buffer)
+		return default_
+	}
+	return r
+}
+
+func (c *Command_Context) Arg_Float(key string, default_ float64) float64 {
+	if !strings.HasPrefix(key, "-") && key != "_" {
+		bone.Log_Error("Unable to search argument via non-flag key '%s'", key)
+		return default_
+	}
+
+	tokens, ok := c.args[key]
+	if !ok {
+		return default_
+	}
+	buffer := strings.Join(tokens, " ")
+	r, er := strconv.ParseFloat(buffer, 64)
+	if er != nil {
+		report_error("Unable to convert argument '%s' value '%s' to float", key, buffer)
+		return default_
+	}
+	return r
+}
+
+func Get_Hook(i int) any {
+	if i >= len(current.hooks) {
+		return nil
+	}
+	return current.hooks[i]
+}
+
+func Set_Hooks[T any](items []T) {
+	Clear_Hooks()
+	for _, i := range items {
+		current.hooks = append(current.hooks, i)
+	}
+}
+
+func Clear_Hooks() {
+	current.hooks = []any{}
+}
+
+func Answer_Prompt(answer bool) int {
+	if !current.prompted {
+		bone.Log_Error("Inactive prompt")
+		return ERROR
+	}
+	current.prompted = false
+	callback := current.prompted_callback
+	current.prompted_callback = nil
+	e := callback(answer)
+	if e != OK {
+		bone.Log_Error("During prompted callback, an error #%d occured", e)
+	}
+	return e
+}
+
+func Prompt(text string, callback func(answer bool) int) {
+	if current.prompted {
+		bone.Log_Error("Already prompted")
+		return
+	}
+	current.prompted = true
+	current.prompted_callback = callback
+	bone.Log(text + " [Y/N]")
+}
+
+// Asks for a line of text, the next input is passed to the callback instead of
+// being executed. Callback may ask again, e.g. to repeat an invalid answer.
+func Ask(question string, callback func(answer string) int) {
+	if current.asked != "" {
+		bone.Log_Error("Already asked")
+		return
+	}
+	current.asked = question
+	current.asked_callback = callback
+}
+
+// Drops pending question and prompt without answering them.
+func Cancel() {
+	if current.asked == "" && !current.prompted {
+		return
+	}
+	current.asked = ""
+	current.asked_callback = nil
+	current.prompted = false
+	current.prompted_callback = nil
+	bone.Log("Cancelled")
+}
+
+func Is_Interactive() bool {
+	return interactive
+}
+
+// Executes a line of input, returns result of the command.
+func Execute(input string) int {
+	return execute(input, nil)
+}
+
+// Executes with the writer, or with one chosen by the `-o` flag if it's nil.
+func execute(input string, out Writer) int {
+	if current.asked != "" {
+		callback := current.asked_callback
+		current.asked = ""
+		current.asked_callback = nil
+		return callback(input)
+	}
+
+	input_parts, e := Tokenize(input)
+	if e != OK {
+		return ERROR
+	}
+	if len(input_parts) == 0 {
+		return OK
+	}
+
+	if current.prompted {
+		var answer bool
+		switch input {
+		case "y":
+			answer = true
+		case "n":
+			answer = false
+		case "Y":
+			answer = true
+		case "N":
+			answer = false
+		default:
+			bone.Log("Type answer 'Y' or 'N'")
+			return ERROR
+		}
+		return Answer_Prompt(answer)
+	}
+
+	command_name := input_parts[0].Text
+
+	cmd := Get_Command(command_name)
+	if cmd == nil {
+		report_error("Unrecognized command: %s, type 'help' to list commands", command_name)
+		return ERROR
+	}
+
+	raw_args := []Token{}
+	if len(input_parts) > 1 {
+		raw_args = input_parts[1:]
+	}
+	ctx := Command_Context{
+		Raw_Input:    input,
+		Command_Name: command_name,
+		Locale:       captured_locale,
+	}
+	if ctx.Locale == "" {
+		ctx.Locale = bone.Get_Locale()
+	}
+	ctx.parse(raw_args)
+	format := ctx.Arg_String("-o", FORMAT_HUMAN)
+	delete(ctx.args, "-o")
+	if out == nil {
+		out = new_writer(format, ctx.Locale)
+		if out == nil {
+			report_error("Unknown output format '%s', expected one of: human, json, csv", format)
+			return ERROR
+		}
+	}
+	ctx.Out = out
+	restore := redirect_log(out)
+	defer restore()
+	defer out.Flush()
+
+	e = ctx.validate(cmd)
+	if e != OK {
+		return e
+	}
+	return cmd.Handler(&ctx)
+}
+
+func Init() {
+	Set_Command(&Command{
+		Name:        "help",
+		Description: "Lists commands or describes one of them.",
+		Args: []*Arg_Spec{
+			{Key: "_", Name: "COMMAND", Type: ARG_STRING, Description: "Command to describe."},
+		},
+		Handler: help,
+		Complete: func(tokens []string) []Completion {
+			if len(tokens) > 1 {
+				return nil
+			}
+			r := []Completion{}
+			for _, cmd := range Get_Commands() {
+				r = append(r, Completion{Value: cmd.Name, Hint: cmd.Description})
+			}
+			return r
+		},
+	})
+}
+
+func prompt_string() string {
+	var final_sign = ">"
+	if current.asked != "" {
+		return fmt.Sprintf("\033[36m%s\033[0m ", current.asked)
+	}
+	if current.prompted {
+		final_sign = "?"
+	}
+	return fmt.Sprintf("\033[33m(%s)\033[0m\033[35m%s\033[0m ", domain, final_sign)
+}
+
+// Runs the interactive shell if both stdin and stdout are terminals,
+// otherwise executes commands from stdin in batch mode. Returns result of
+// the last command.
+func Run() int {
+	if !Is_Terminal(int(os.Stdin.Fd())) || !Is_Terminal(int(os.Stdout.Fd())) {
+		return Run_Batch(os.Stdin)
+	}
+
+	ed := &editor{reader: bufio.NewReader(os.Stdin)}
+	ed.load_history()
+	return run_interactive(ed.read_line, ed.add_history)
+}
+
+// Runs the interactive shell over lines of the reader instead of the
+// terminal, so answers to questions and prompts can be scripted. A line of a
+// single Ctrl-C character cancels the pending question, like the key does.
+func Run_Scripted(reader io.Reader) int {
+	scanner := bufio.NewScanner(reader)
+	read := func(prompt string) (string, int) {
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line != string(rune(KEY_CTRL_C)) {
+				return line, OK
+			}
+			if current.asked != "" || current.prompted {
+				return "", read_cancelled
+			}
+		}
+		return "", ERROR
+	}
+	return run_interactive(read, func(line string) {})
+}
+
+// Reads lines until the end of input or `q`, executing them. Read returns
+// `read_cancelled` to drop the pending question.
+func run_interactive(read func(prompt string) (string, int), add_history func(line string)) int {
+	interactive = true
+	defer func() {
+		interactive = false
+	}()
+
+	// Main loop is blocking on input, other background tasks are goroutines.
+	for {
+		input, e := read(prompt_string())
+		if e == read_cancelled {
+			Cancel()
+			continue
+		}
+		if e != OK {
+			return OK
+		}
+		input = strings.TrimSpace(input)
+		// Answers are neither commands nor history entries.
+		if current.asked != "" {
+			Execute(input)
+			continue
+		}
+		if input == "q" {
+			return OK
+		}
+		add_history(input)
+		Execute(input)
+	}
+}
+
+// Executes commands line by line without prompt and colors, stopping at the
+// first failed one. Empty lines and lines starting with `#` are skipped.
+func Run_Batch(reader io.Reader) int {
+	bone.Log_Colors = false
+	scanner := bufio.NewScanner(reader)
+	line_number := 0
+	for scanner.Scan() {
+		line_number++
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue
+		}
+		if input == "q" {
+			return OK
+		}
+		e := Execute(input)
+		if e != OK {
+			report_error("Stopped at line %d: %s", line_number, input)
+			return e
+		}
+	}
+	er := scanner.Err()
+	if er != nil {
+		report_error("Unexpected error occured while reading input: %s", er)
+		return ERROR
+	}
+	return OK
+}
+
+var domain_regex = regexp.MustCompile("^[a-z0-9_]*$")
+
+func Get_Domain() string {
+	return domain
+}
+
+func Is_Valid_Domain(d string) bool {
+	return domain_regex.MatchString(d)
+}
+
+func Set_Domain(d string) int {
+	if !Is_Valid_Domain(d) {
+		report_error("Incorrect domain '%s'", d)
+		return ERROR
+	}
+	domain = d
+	return OK
+}
+
+type Result struct {
+	Code int
+	// Current domain after the command, e.g. changed by `setdomain`.
+	Domain string
+	Output []*Output_Item
+	// Question the command waits an answer for, nil if none.
+	Confirm *Confirmation
+}
+
+var capturing = false
+
+// Locale of the captured command, empty for the configured one.
+var captured_locale = ""
+
+// Reports whether the command runs from `Execute_Captured`, so handlers can
+// skip side effects meant for the interactive shell only.
+func Is_Captured() bool {
+	return capturing
+}
+
+// Serializes captured commands, since they share the shell's state.
+var captured_lock sync.Mutex
+
+// Executes input in the domain, collecting results instead of printing them.
+// Hooks, questions and confirmations are kept in the session of the key, so
+// callers do not see each other's. Messages are translated into the locale,
+// empty one stands for the configured locale. The shell's own domain is
+// restored afterwards.
+func Execute_Captured(input string, session_key string, d string, locale string) *Result {
+	captured_lock.Lock()
+	defer captured_lock.Unlock()
+	out := &capture_writer{items: []*Output_Item{}}
+	previous_domain := domain
+	captured_out = out
+	capturing = true
+	captured_locale = locale
+	use_session(session_key)
+	defer func() {
+		captured_out = nil
+		capturing = false
+		captured_locale = ""
+		domain = previous_domain
+		current = local_session
+	}()
+
+	r := &Result{}
+	captured_confirmation = nil
+	r.Code = Set_Domain(d)
+	if r.Code == OK {
+		r.Code = execute(input, out)
+	}
+	out.ensure_error(r.Code)
+	r.Domain = domain
+	r.Output = out.items
+	r.Confirm = captured_confirmation
+	return r
+}
@@ -0,0 +1,68 @@
+package shell
+
+import (
+	"seva/lib/bone"
+	"strings"
+	"testing"
+)
+
+var batch_lines = []string{}
+
+func init() {
+	Set_Command(&Command{
+		Name: "batchtest",
+		Args: []*Arg_Spec{
+			{Key: "_", Type: ARG_STRING},
+			{Key: "-code", Type: ARG_INT},
+		},
+		Handler: func(c *Command_Context) int {
+			batch_lines = append(batch_lines, c.Arg_String("_", ""))
+			c.Message("Ran %s", c.Arg_String("_", ""))
+			return c.Arg_Int("-code", OK)
+		},
+	})
+}
+
+// Runs the input in batch mode, returning it's code and what it printed to
+// stdout and stderr.
+func run_batch(input string) (int, string, string) {
+	batch_lines = []string{}
+	e := OK
+	errs := ""
+	out := capture_stdout(func() {
+		errs = capture_stderr(func() {
+			e = Run_Batch(strings.NewReader(input))
+		})
+	})
+	return e, out, errs
+}
+
+func Test_run_batch_ok(t *testing.T) {
+	defer func(colors bool) { bone.Log_Colors = colors }(bone.Log_Colors)
+	bone.Log_Colors = true
+
+	e, out, errs := run_batch("# comment\n\nbatchtest a\n  batchtest b  \n")
+	bone.Assert(e == OK && errs == "")
+	bone.Assert(len(batch_lines) == 2 && batch_lines[1] == "b")
+	// Neither prompt nor colors are printed
+	bone.Assert(out == "Ran a\nRan b\n")
+	bone.Assert(!bone.Log_Colors)
+
+	// Lines after `q` are not executed
+	e, _, _ = run_batch("batchtest a\nq\nbatchtest b\n")
+	bone.Assert(e == OK && len(batch_lines) == 1)
+}
+
+func Test_run_batch_error(t *testing.T) {
+	defer func(colors bool) { bone.Log_Colors = colors }(bone.Log_Colors)
+
+	// Stops at the first failed line with it's code
+	e, out, errs := run_batch("batchtest a\nbatchtest b -code 7\nbatchtest c -code 3\n")
+	bone.Assert(e == 7)
+	bone.Assert(len(batch_lines) == 2 && out == "Ran a\nRan b\n")
+	bone.Assert(errs == "ERROR: Stopped at line 2: batchtest b -code 7\n")
+
+	e, _, errs = run_batch("batchtest a\nmissingtest\nbatchtest b\n")
+	bone.Assert(e == ERROR && len(batch_lines) == 1)
+	bone.Assert(!strings.Contains(errs, "\033[") && strings.HasSuffix(errs, "ERROR: Stopped at line 2: missingtest\n"))
+}
@@ -0,0 +1,81 @@
+package shell
+
+import (
+	"regexp"
+	"seva/lib/bone"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prints rows with columns aligned to the widest cell. Rows are numbered from
+// 1, so the numbers can be referred as `@N` by the next command, see
+// `Resolve_Hook`.
+func Print_Table(headers []string, rows [][]string) {
+	headers = append([]string{"#"}, headers...)
+	numbered := make([][]string, len(rows))
+	for i, row := range rows {
+		numbered[i] = append([]string{strconv.Itoa(i + 1)}, row...)
+	}
+
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range numbered {
+		for i, cell := range row {
+			if i < len(widths) && utf8.RuneCountInString(cell) > widths[i] {
+				widths[i] = utf8.RuneCountInString(cell)
+			}
+		}
+	}
+
+	bone.Log(format_row(headers, widths))
+	for _, row := range numbered {
+		bone.Log(format_row(row, widths))
+	}
+}
+
+func format_row(cells []string, widths []int) string {
+	var b strings.Builder
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		b.WriteString(cell)
+		if i < len(widths) {
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// Prints key-value pairs with aligned values.
+func Print_Record(keys []string, values []string) {
+	width := 0
+	for _, k := range keys {
+		if utf8.RuneCountInString(k) > width {
+			width = utf8.RuneCountInString(k)
+		}
+	}
+	for i, k := range keys {
+		bone.Log("%s%s  %s", k, strings.Repeat(" ", width-utf8.RuneCountInString(k)), values[i])
+	}
+}
+
+var hook_regex = regexp.MustCompile(`^@([0-9]+)$`)
+
+// Returns hooked item if the token refers to a row of the last listing in
+// form of `@N`.
+func Resolve_Hook(token string) (any, bool) {
+	match := hook_regex.FindStringSubmatch(token)
+	if match == nil {
+		return nil, false
+	}
+	n, _ := strconv.Atoi(match[1])
+	if n < 1 {
+		return nil, false
+	}
+	item := Get_Hook(n - 1)
+	return item, item != nil
+}
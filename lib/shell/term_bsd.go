@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package shell
+
+import "golang.org/x/sys/unix"
+
+const ioctl_get_termios = unix.TIOCGETA
+const ioctl_set_termios = unix.TIOCSETA
@@ -0,0 +1,8 @@
+//go:build linux
+
+package shell
+
+import "golang.org/x/sys/unix"
+
+const ioctl_get_termios = unix.TCGETS
+const ioctl_set_termios = unix.TCSETS
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package shell
+
+// Raw mode is not supported, the shell falls back to reading plain lines.
+func make_raw(fd int) (func(), int) {
+	return nil, ERROR
+}
+
+func wait_input(fd int, timeout_ms int) bool {
+	return true
+}
+
+func Is_Terminal(fd int) bool {
+	return false
+}
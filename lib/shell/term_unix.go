@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package shell
+
+import "golang.org/x/sys/unix"
+
+// Switches terminal to raw mode, where input is read by keys without echo.
+// Output processing is kept, so `\n` still returns the carriage. Returns
+// ERROR if the descriptor is not a terminal.
+func make_raw(fd int) (func(), int) {
+	termios, er := unix.IoctlGetTermios(fd, ioctl_get_termios)
+	if er != nil {
+		return nil, ERROR
+	}
+	old := *termios
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	er = unix.IoctlSetTermios(fd, ioctl_set_termios, termios)
+	if er != nil {
+		return nil, ERROR
+	}
+
+	return func() {
+		unix.IoctlSetTermios(fd, ioctl_set_termios, &old)
+	}, OK
+}
+
+// Waits until the descriptor has input to read, returns false on timeout.
+func wait_input(fd int, timeout_ms int) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, er := unix.Poll(fds, timeout_ms)
+		if er == unix.EINTR {
+			continue
+		}
+		return er == nil && n > 0
+	}
+}
+
+func Is_Terminal(fd int) bool {
+	_, er := unix.IoctlGetTermios(fd, ioctl_get_termios)
+	return er == nil
+}
@@ -0,0 +1,109 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Part of the input separated by whitespace.
+type Token struct {
+	Text string
+	// Set if any part of the token is quoted or escaped. Such tokens are
+	// taken literally, so `"-5"` is a value rather than a flag.
+	Quoted bool
+}
+
+// Splits input to tokens by whitespace.
+//
+// Single quotes preserve everything inside literally. Double quotes preserve
+// whitespace, but backslash escapes are still processed within them, as
+// everywhere else outside of single quotes. Quotes may appear in the middle
+// of a token, so `key="value with spaces"` is a single token
+// `key=value with spaces`.
+func Tokenize(input string) ([]Token, int) {
+	tokens, problem := tokenize(input)
+	if problem != "" {
+		report_error("%s", problem)
+		return nil, ERROR
+	}
+	return tokens, OK
+}
+
+// Returns a description of the problem instead of logging it, for the
+// callers which handle incomplete input, like completion.
+func tokenize(input string) ([]Token, string) {
+	tokens := []Token{}
+	var token strings.Builder
+	// Empty quotes still produce a token, so we track it separately from the
+	// builder's length.
+	in_token := false
+	quoted := false
+	var quote rune = 0
+	escaped := false
+
+	for _, r := range input {
+		if escaped {
+			token.WriteRune(r)
+			escaped = false
+			continue
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+			token.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			in_token = true
+			quoted = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+			token.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			in_token = true
+			quoted = true
+		case unicode.IsSpace(r):
+			if in_token {
+				tokens = append(tokens, Token{Text: token.String(), Quoted: quoted})
+				token.Reset()
+				in_token = false
+				quoted = false
+			}
+		default:
+			token.WriteRune(r)
+			in_token = true
+		}
+	}
+
+	if escaped {
+		return nil, "Unfinished escape sequence at the end of input"
+	}
+	if quote != 0 {
+		return nil, fmt.Sprintf("Unterminated quote %c", quote)
+	}
+	if in_token {
+		tokens = append(tokens, Token{Text: token.String(), Quoted: quoted})
+	}
+	return tokens, ""
+}
+
+// Returns texts of the tokens.
+func token_texts(tokens []Token) []string {
+	r := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		r = append(r, token.Text)
+	}
+	return r
+}
+
+// Splits `key=value` token by the first `=`, so values may contain it.
+func Split_Pair(token string) (string, string, bool) {
+	return strings.Cut(token, "=")
+}
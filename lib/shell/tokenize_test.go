@@ -0,0 +1,70 @@
+package shell
+
+import (
+	"seva/lib/bone"
+	"slices"
+	"testing"
+)
+
+// Returns unquoted tokens of the texts.
+func test_tokens(texts ...string) []Token {
+	r := []Token{}
+	for _, text := range texts {
+		r = append(r, Token{Text: text})
+	}
+	return r
+}
+
+func Test_tokenize_ok(t *testing.T) {
+	var tokens []Token
+	var e int
+
+	tokens, e = Tokenize("")
+	bone.Assert(e == OK && len(tokens) == 0)
+
+	tokens, e = Tokenize("  addevent   ORDER id=1 ")
+	bone.Assert(e == OK && slices.Equal(tokens, test_tokens("addevent", "ORDER", "id=1")))
+
+	tokens, e = Tokenize(`note="value with spaces" other='a=b "c"'`)
+	bone.Assert(e == OK && slices.Equal(token_texts(tokens), []string{"note=value with spaces", `other=a=b "c"`}))
+
+	tokens, e = Tokenize(`a\ b "x\"y" 'x\y' ""`)
+	bone.Assert(e == OK && slices.Equal(token_texts(tokens), []string{"a b", `x"y`, `x\y`, ""}))
+
+	// Quoted or escaped parts mark the whole token
+	tokens, e = Tokenize(`-n "-5" '-yes' \-x note="-a" -o`)
+	bone.Assert(e == OK && slices.Equal(tokens, []Token{
+		{Text: "-n"},
+		{Text: "-5", Quoted: true},
+		{Text: "-yes", Quoted: true},
+		{Text: "-x", Quoted: true},
+		{Text: "note=-a", Quoted: true},
+		{Text: "-o"},
+	}))
+}
+
+func Test_tokenize_error(t *testing.T) {
+	var e int
+
+	_, e = Tokenize(`note="unterminated`)
+	bone.Assert(e == ERROR)
+
+	_, e = Tokenize(`trailing\`)
+	bone.Assert(e == ERROR)
+}
+
+func Test_parse_ok(t *testing.T) {
+	c := Command_Context{}
+	c.parse(test_tokens("ORDER", "note=a b", "-n", "-5", "-yes"))
+	bone.Assert(slices.Equal(c.Arg_List("_"), []string{"ORDER", "note=a b"}))
+	bone.Assert(c.Arg_Int("-n", 0) == -5)
+	bone.Assert(c.Arg_Bool("-yes", false))
+	bone.Assert(!c.Arg_Bool("-no", false))
+
+	// Quoted tokens are never flags
+	tokens, e := Tokenize(`search "-yes" '-draft note' -n 5`)
+	bone.Assert(e == OK)
+	c.parse(tokens[1:])
+	bone.Assert(slices.Equal(c.Arg_List("_"), []string{"-yes", "-draft note"}))
+	bone.Assert(!c.Arg_Bool("-yes", false) && c.Arg_Int("-n", 0) == 5)
+}
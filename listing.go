@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DATE_FORMAT = "2006-01-02 15:04:05"
+
+// Events of the same second are told apart by milliseconds.
+const EVENT_DATE_FORMAT = "2006-01-02 15:04:05.000"
+
+type Domain_Info struct {
+	Name       string
+	Signatures int
+	Events     int
+}
+
+type Signature_Info struct {
+	// Integer type of the signature's events.
+	Id        int
+	Events    int
+	Signature *Event_Signature
+}
+
+// Formats fields as `key=value` pairs sorted by keys, quoting values which
+// would not survive shell tokenization.
+func format_fields(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := fields[k]
+		if value == "" || strings.ContainsAny(value, " \t\"'\\") {
+			value = strconv.Quote(value)
+		}
+		parts = append(parts, k+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func type_name_of(sigs []*Event_Signature, event *Event) string {
+	if event.Type < 1 || event.Type > len(sigs) {
+		return fmt.Sprintf("?%d", event.Type)
+	}
+	return sigs[event.Type-1].Type_Name
+}
+
+func event_type_name(domain string, event *Event) string {
+	return type_name_of(signatures[domain], event)
+}
+
+func find_signature(domain string, type_name string) (int, *Event_Signature) {
+	type_name = strings.ToUpper(type_name)
+	for i, signature := range signatures[domain] {
+		if signature.Type_Name == type_name && !signature.Deleted {
+			return i + 1, signature
+		}
+	}
+	return 0, nil
+}
+
+func sorted_domains() []string {
+	domains := make([]string, 0, len(signatures))
+	for domain := range signatures {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func domain_infos() []*Domain_Info {
+	infos := []*Domain_Info{}
+	for _, domain := range sorted_domains() {
+		infos = append(infos, &Domain_Info{
+			Name:       domain,
+			Signatures: len(signature_infos(domain)),
+			Events:     event_count(domain),
+		})
+	}
+	return infos
+}
+
+func signature_infos(domain string) []*Signature_Info {
+	counts := event_counts[domain]
+	infos := []*Signature_Info{}
+	for i, signature := range signatures[domain] {
+		if signature.Deleted {
+			continue
+		}
+		infos = append(infos, &Signature_Info{
+			Id:        i + 1,
+			Events:    counts[i+1],
+			Signature: signature,
+		})
+	}
+	return infos
+}
+
+func render_domains(c *shell.Command_Context, infos []*Domain_Info) {
+	rows := make([][]string, 0, len(infos))
+	domains := make([]string, 0, len(infos))
+	for _, info := range infos {
+		current := ""
+		if info.Name == shell.Get_Domain() {
+			current = "*"
+		}
+		rows = append(rows, []string{
+			info.Name,
+			strconv.Itoa(info.Signatures),
+			strconv.Itoa(info.Events),
+			current,
+		})
+		domains = append(domains, info.Name)
+	}
+	c.Table([]string{"DOMAIN", "SIGS", "EVENTS", "CURRENT"}, rows)
+	shell.Set_Hooks(domains)
+}
+
+func render_signatures(c *shell.Command_Context, infos []*Signature_Info) {
+	rows := make([][]string, 0, len(infos))
+	sigs := make([]*Event_Signature, 0, len(infos))
+	for _, info := range infos {
+		keys := make([]string, 0, len(info.Signature.Fields))
+		for k := range info.Signature.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := make([]string, 0, len(keys))
+		for _, k := range keys {
+			field := k + ":" + info.Signature.Fields[k]
+			default_, ok := info.Signature.Defaults[k]
+			if ok {
+				field += ":" + default_
+			}
+			fields = append(fields, field)
+		}
+		state := ""
+		if info.Signature.Deprecated {
+			state = "deprecated"
+		}
+		rows = append(rows, []string{
+			info.Signature.Type_Name,
+			strconv.Itoa(info.Id),
+			strconv.Itoa(info.Events),
+			state,
+			strings.Join(fields, " "),
+		})
+		sigs = append(sigs, info.Signature)
+	}
+	c.Table([]string{"TYPE", "ID", "EVENTS", "STATE", "FIELDS"}, rows)
+	shell.Set_Hooks(sigs)
+}
+
+func render_signature(c *shell.Command_Context, signature *Event_Signature) {
+	keys := make([]string, 0, len(signature.Fields))
+	for k := range signature.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, []string{k, signature.Fields[k], signature.Defaults[k]})
+	}
+	c.Message("%s", signature.Type_Name)
+	c.Table([]string{"FIELD", "TYPE", "DEFAULT"}, rows)
+}
+
+func render_events(c *shell.Command_Context, sigs []*Event_Signature, evs []*Event) {
+	rows := make([][]string, 0, len(evs))
+	for _, event := range evs {
+		rows = append(rows, []string{
+			strconv.Itoa(event.Seq),
+			bone.Date_Ms(event.Created_Ms, EVENT_DATE_FORMAT),
+			type_name_of(sigs, event),
+			format_fields(event.Fields),
+		})
+	}
+	c.Table([]string{"SEQ", "TIME", "TYPE", "FIELDS"}, rows)
+	shell.Set_Hooks(evs)
+}
+
+func render_event(c *shell.Command_Context, sigs []*Event_Signature, event *Event) {
+	keys := []string{"SEQ", "TIME", "TYPE", "HASH"}
+	values := []string{
+		strconv.Itoa(event.Seq),
+		bone.Date_Ms(event.Created_Ms, EVENT_DATE_FORMAT),
+		type_name_of(sigs, event),
+		event.Hash,
+	}
+	field_keys := make([]string, 0, len(event.Fields))
+	for k := range event.Fields {
+		field_keys = append(field_keys, k)
+	}
+	sort.Strings(field_keys)
+	for _, k := range field_keys {
+		keys = append(keys, k)
+		values = append(values, event.Fields[k])
+	}
+	c.Record(keys, values)
+}
+
+// Resolves `@N` reference to a listed signature, or returns the argument as
+// a type name.
+func hooked_signature(arg string) (*Event_Signature, string) {
+	hook, ok := shell.Resolve_Hook(arg)
+	if ok {
+		signature, _ := hook.(*Event_Signature)
+		return signature, ""
+	}
+	return nil, arg
+}
+
+// Resolves `@N` reference to a listed event, or parses the argument as a
+// sequence.
+func hooked_event(arg string) (*Event, int, error) {
+	hook, ok := shell.Resolve_Hook(arg)
+	if ok {
+		event, _ := hook.(*Event)
+		if event == nil {
+			return nil, 0, ERR_ROW_NOT_EVENT.With("row", arg)
+		}
+		return event, event.Seq, nil
+	}
+	seq, er := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if er != nil {
+		return nil, 0, ERR_INVALID_SEQ.With("value", arg)
+	}
+	return nil, seq, nil
+}
+
+func shell_domains(c *shell.Command_Context) int {
+	render_domains(c, domain_infos())
+	return shell.OK
+}
+
+func shell_sigs(c *shell.Command_Context) int {
+	render_signatures(c, signature_infos(shell.Get_Domain()))
+	return shell.OK
+}
+
+func shell_sig(c *shell.Command_Context) int {
+	arg := c.Arg_String("_", "")
+	signature, type_name := hooked_signature(arg)
+	if signature == nil && type_name != "" {
+		_, signature = find_signature(shell.Get_Domain(), type_name)
+	}
+	if signature == nil {
+		return c.Fail(ERR_SIGNATURE_NOT_FOUND.With("type", arg))
+	}
+	render_signature(c, signature)
+	return shell.OK
+}
+
+func shell_events(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	evs, er := select_events(domain, c.Arg_Int("-n", 20), c.Arg_String("-type", ""), c.Arg_String("-time", ""))
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_events(c, signatures[domain], evs)
+	return shell.OK
+}
+
+func shell_event(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	arg := c.Arg_String("_", "")
+	event, seq, er := hooked_event(arg)
+	if er != nil {
+		return c.Fail(er)
+	}
+	if event == nil {
+		event, er = find_event(domain, seq)
+		if er != nil {
+			return c.Fail(er)
+		}
+	}
+	if event == nil {
+		return c.Fail(ERR_EVENT_NOT_FOUND.With("seq", arg))
+	}
+	render_event(c, signatures[domain], event)
+	return shell.OK
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"testing"
+)
+
+func Test_format_fields_ok(t *testing.T) {
+	fields := map[string]string{"b": "two words", "a": "1", "c": "", "d": `say "hi"`}
+	text := format_fields(fields)
+	bone.Assert(text == `a=1 b="two words" c="" d="say \"hi\""`)
+
+	// Formatted fields are accepted back by the shell
+	tokens, e := shell.Tokenize(text)
+	bone.Assert(e == shell.OK)
+	texts := []string{}
+	for _, token := range tokens {
+		texts = append(texts, token.Text)
+	}
+	parsed, er := parse_pairs(texts)
+	bone.Assert(er == nil && len(parsed) == 4)
+	for k, v := range fields {
+		bone.Assert(parsed[k] == v)
+	}
+}
+
+func Test_signature_infos_ok(t *testing.T) {
+	test_domain("listing_test")
+	_, er := add_signature("listing_test", "A", map[string]string{})
+	bone.Assert(er == nil)
+	_, er = add_signature("listing_test", "B", map[string]string{})
+	bone.Assert(er == nil)
+	_, er = add_signature("listing_test", "C", map[string]string{})
+	bone.Assert(er == nil)
+	test_event("listing_test", "C", map[string]string{})
+	test_event("listing_test", "C", map[string]string{})
+	bone.Assert(delete_signature("listing_test", "B") == nil)
+
+	infos := signature_infos("listing_test")
+	bone.Assert(len(infos) == 2)
+	bone.Assert(infos[0].Id == 1 && infos[0].Events == 0)
+	bone.Assert(infos[1].Id == 3 && infos[1].Events == 2)
+	bone.Assert(type_name_of(signatures["listing_test"], &Event{Type: 4}) == "?4")
+}
+
+func Test_hooked_event_ok(t *testing.T) {
+	event := &Event{Seq: 7}
+	shell.Set_Hooks([]*Event{event})
+	defer shell.Clear_Hooks()
+
+	found, seq, er := hooked_event("@1")
+	bone.Assert(er == nil && found == event && seq == 7)
+	found, seq, er = hooked_event("#12")
+	bone.Assert(er == nil && found == nil && seq == 12)
+	_, _, er = hooked_event("twelve")
+	bone.Assert(er != nil)
+
+	shell.Set_Hooks([]string{"domain"})
+	_, _, er = hooked_event("@1")
+	bone.Assert(er != nil)
+}
@@ -1,447 +1,840 @@
-package main
-
-import (
-	"encoding/json"
-	"flag"
-	"os"
-	"path/filepath"
-	"seva/lib/bone"
-	"seva/lib/shell"
-	"strconv"
-	"strings"
-
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
-)
-
-const (
-	OK = iota
-	ERROR
-)
-
-type Event_Signature struct {
-	// Integer type is index of signature in the state array.
-	Type_Name string `json:"type_name"`
-	// Values can be:
-	//   - int
-	//   - string
-	//   - float
-	//   - array
-	//   - dict
-	//   - bool
-	Fields map[string]string `json:"fields"`
-}
-
-type Event struct {
-	// Time of event injection.
-	Created_Sec int `json:"created_sec"`
-	// Integer type of an event. Each project has own unsigned set of types,
-	// starting from 1.
-	Type   int               `json:"type"`
-	Fields map[string]string `json:"fields"`
-}
-
-// Domains by their list of events
-var events = map[string][]*Event{}
-
-// Domains by their list of event signatures
-var signatures = map[string][]*Event_Signature{}
-
-// Event files by their domains
-var eventfiles = map[string]*os.File{}
-
-// Signature files by their domains
-var sigfiles = map[string]*os.File{}
-
-func read_event_state() int {
-	dir := bone.Userdir("events")
-	bone.Mkdir(dir)
-	files, er := os.ReadDir(dir)
-	if er != nil {
-		bone.Log_Error("During state reading, cannot read userdir, error: %s", er)
-		return ERROR
-	}
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			path := filepath.Join(dir, file.Name())
-			data, er := os.ReadFile(path)
-			if er != nil {
-				bone.Log_Error("During state reading, cannot read file '%s', error: %s", path, er)
-				return ERROR
-			}
-			domain, _ := strings.CutSuffix(file.Name(), filepath.Ext(file.Name()))
-			evs := []*Event{}
-			er = json.Unmarshal(data, &evs)
-			events[domain] = evs
-			if er != nil {
-				bone.Log_Error("Cannot unmarshal file '%s', error: %s", file.Name(), er)
-				return ERROR
-			}
-		}
-	}
-	return OK
-}
-
-func read_signature_state() int {
-	dir := bone.Userdir("signatures")
-	bone.Mkdir(dir)
-	files, er := os.ReadDir(dir)
-	if er != nil {
-		bone.Log_Error("During state reading, cannot read userdir, error: %s", er)
-		return ERROR
-	}
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			path := filepath.Join(dir, file.Name())
-			data, er := os.ReadFile(path)
-			if er != nil {
-				bone.Log_Error("During state reading, cannot read file '%s', error: %s", path, er)
-				return ERROR
-			}
-			domain, _ := strings.CutSuffix(file.Name(), filepath.Ext(file.Name()))
-			sigs := []*Event_Signature{}
-			er = json.Unmarshal(data, &sigs)
-			signatures[domain] = sigs
-			if er != nil {
-				bone.Log_Error("Cannot unmarshal file '%s', error: %s", file.Name(), er)
-				return ERROR
-			}
-		}
-	}
-	return OK
-}
-
-// Read all files in userdir and unmarshal them to state.
-func read_state() int {
-	e := read_signature_state()
-	if e != OK {
-		return e
-	}
-	e = read_event_state()
-	if e != OK {
-		return e
-	}
-
-	// Add "main" domain if does not exist
-	_, ok := signatures["main"]
-	if !ok {
-		signatures["main"] = []*Event_Signature{}
-		events["main"] = []*Event{}
-		save_state()
-	}
-
-	return OK
-}
-
-func save_state() {
-	eventdir := bone.Userdir("events")
-	bone.Mkdir(eventdir)
-	for domain, evs := range events {
-		data, er := json.MarshalIndent(evs, "", "\t")
-		if er != nil {
-			bone.Log_Error("Error marshalling state to json for domain '%s'", domain)
-			return
-		}
-
-		f, ok := eventfiles[domain]
-		if !ok {
-			path := filepath.Join(eventdir, domain+".json")
-			f, er = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
-			if er != nil {
-				bone.Log_Error("Cannot open data file for domain '%s' at path '%s'", domain, path)
-				continue
-			}
-			eventfiles[domain] = f
-		}
-
-		er = f.Truncate(0)
-		if er != nil {
-			bone.Log_Error("Cannot truncate file for domain '%s'", domain)
-			continue
-		}
-
-		_, er = f.Seek(0, 0)
-		if er != nil {
-			bone.Log_Error("Cannot seek file of domain '%s'", domain)
-			continue
-		}
-
-		_, er = f.Write(data)
-		if er != nil {
-			bone.Log_Error("Cannot write to a file for domain '%s'", domain)
-			continue
-		}
-	}
-
-	sigdir := bone.Userdir("signatures")
-	bone.Mkdir(sigdir)
-	for domain, sigs := range signatures {
-		data, er := json.MarshalIndent(sigs, "", "\t")
-		if er != nil {
-			bone.Log_Error("Error marshalling state to json for domain '%s'", domain)
-			return
-		}
-
-		f, ok := sigfiles[domain]
-		if !ok {
-			path := filepath.Join(sigdir, domain+".json")
-			f, er = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
-			if er != nil {
-				bone.Log_Error("Cannot open data file for domain '%s' at path '%s'", domain, path)
-				continue
-			}
-			sigfiles[domain] = f
-		}
-
-		er = f.Truncate(0)
-		if er != nil {
-			bone.Log_Error("Cannot truncate file for domain '%s'", domain)
-			continue
-		}
-
-		_, er = f.Seek(0, 0)
-		if er != nil {
-			bone.Log_Error("Cannot seek file of domain '%s'", domain)
-			continue
-		}
-
-		_, er = f.Write(data)
-		if er != nil {
-			bone.Log_Error("Cannot write to a file for domain '%s'", domain)
-			continue
-		}
-	}
-}
-
-func create_server() *gin.Engine {
-	server := gin.New()
-	server.Use(gin.Recovery())
-	server.Use(cors.Default())
-
-	return server
-}
-
-func deinit() {
-	for _, f := range eventfiles {
-		f.Close()
-	}
-	for k := range eventfiles {
-		delete(eventfiles, k)
-	}
-}
-
-func main() {
-	defer deinit()
-
-	shell_enabled := flag.Bool("shell", false, "Enables shell mode.")
-	bone.Init("seva")
-
-	e := read_state()
-	if e != OK {
-		bone.Log_Error("During state reading, an error occurred: %d", e)
-		os.Exit(e)
-		return
-	}
-
-	if *shell_enabled {
-		shell.Init()
-
-		domain := bone.Config.Get_String("main", "domain", "main")
-		shell.Set_Domain(domain)
-
-		shell.Set_Command("setdomain", shell_set_domain)
-		shell.Set_Command("addevent", shell_add_event)
-		shell.Set_Command("addsig", shell_add_signature)
-		shell.Set_Command("ae", shell_add_event)
-		shell.Set_Command("as", shell_add_signature)
-
-		save_state()
-		shell.Run()
-		return
-	}
-
-	server := create_server()
-	server.Run("0.0.0.0:3000")
-}
-
-func shell_add_signature(c *shell.Command_Context) int {
-	buffer := c.Arg_String("_", "")
-	if buffer == "" {
-		bone.Log_Error("Specify at least event type")
-		return shell.ERROR
-	}
-	parts := strings.Split(buffer, " ")
-	str_type := strings.ToUpper(parts[0])
-	domain := shell.Get_Domain()
-
-	for _, signature := range signatures[domain] {
-		if signature.Type_Name == str_type {
-			bone.Log_Error("Signature '%s' already exist", str_type)
-			return shell.ERROR
-		}
-	}
-
-	fields := map[string]string{}
-
-	for i, part := range parts {
-		if i == 0 {
-			continue
-		}
-		subparts := strings.Split(part, "=")
-		if len(subparts) != 2 {
-			bone.Log_Error("Invalid part '%s'", part)
-			return shell.ERROR
-		}
-		key := subparts[0]
-		value := subparts[1]
-
-		// We store string anyways, but check signature
-		switch value {
-		case "int":
-		case "string":
-		case "float":
-		case "bool":
-		case "array":
-		case "dict":
-		default:
-			bone.Log_Error("Unrecognized signature value '%s' for event '%s'", value, str_type)
-			return shell.ERROR
-		}
-		fields[key] = value
-	}
-
-	signature := &Event_Signature{
-		Type_Name: str_type,
-		Fields:    fields,
-	}
-	_, ok := signatures[domain]
-	if !ok {
-		signatures[domain] = []*Event_Signature{}
-	}
-	signatures[domain] = append(signatures[domain], signature)
-
-	save_state()
-	return shell.OK
-}
-
-func shell_add_event(c *shell.Command_Context) int {
-	buffer := c.Arg_String("_", "")
-	if buffer == "" {
-		bone.Log_Error("Specify at least event type")
-		return shell.ERROR
-	}
-	parts := strings.Split(buffer, " ")
-	str_type := strings.ToUpper(parts[0])
-
-	domain := shell.Get_Domain()
-	sigs, ok := signatures[domain]
-	if !ok {
-		bone.Log_Error("Cannot find domain '%s'", domain)
-		return shell.ERROR
-	}
-	var target_signature_type int
-	var target_signature *Event_Signature = nil
-	for i, signature := range sigs {
-		if signature.Type_Name == str_type {
-			target_signature_type = i + 1
-			target_signature = signature
-		}
-	}
-	if target_signature == nil {
-		bone.Log_Error("Cannot find signature for type '%s'", str_type)
-		return shell.ERROR
-	}
-
-	// Parse event fields and compare with signature
-	fields := map[string]string{}
-	for i, part := range parts {
-		if i == 0 {
-			continue
-		}
-		subparts := strings.Split(part, "=")
-		if len(subparts) != 2 {
-			bone.Log_Error("Invalid part '%s'", part)
-			return shell.ERROR
-		}
-		key := subparts[0]
-		value := subparts[1]
-		sig_value, ok := target_signature.Fields[key]
-		if !ok {
-			bone.Log_Error("No field with key '%s' in signature for event '%s'", key, str_type)
-			return shell.ERROR
-		}
-
-		// We store string anyways, but check signature
-		switch sig_value {
-		case "int":
-			_, er := strconv.Atoi(value)
-			if er != nil {
-				bone.Log_Error("Cannot convert value '%s' to int for event of type '%s'", sig_value, str_type)
-				return shell.ERROR
-			}
-		case "string":
-		case "float":
-			_, er := strconv.ParseFloat(value, 64)
-			if er != nil {
-				bone.Log_Error("Cannot convert value '%s' to float for event of type '%s'", sig_value, str_type)
-				return shell.ERROR
-			}
-		case "bool":
-			if value != "1" && value != "0" && value != "true" && value != "false" {
-				bone.Log_Error("Cannot convert value '%s' to bool for event of type '%s'", sig_value, str_type)
-				return shell.ERROR
-			}
-		// @Todo implement parsers for arr and dict
-		case "array":
-		case "dict":
-		default:
-			bone.Log_Error("Unrecognized value '%s' for signature of event '%s'", sig_value, str_type)
-			return shell.ERROR
-		}
-		fields[key] = value
-	}
-
-	event := &Event{
-		Created_Sec: int(bone.Utc()),
-		Type:        target_signature_type,
-		Fields:      fields,
-	}
-
-	evs, ok := events[domain]
-	if !ok {
-		bone.Log_Error("Cannot find domain '%s'", domain)
-		return shell.ERROR
-	}
-	events[domain] = append(evs, event)
-
-	save_state()
-	return shell.OK
-}
-
-func shell_set_domain(c *shell.Command_Context) int {
-	domain := c.Arg_String("_", "main")
-
-	if shell.Get_Domain() == domain {
-		return shell.OK
-	}
-
-	e := shell.Set_Domain(domain)
-	if e != OK {
-		return shell.ERROR
-	}
-
-	// Cache domain in config for future logins
-	bone.Config.Write_String("main", "domain", domain)
-
-	_, ok := events[domain]
-	if !ok {
-		events[domain] = []*Event{}
-	}
-	_, ok = signatures[domain]
-	if !ok {
-		signatures[domain] = []*Event_Signature{}
-	}
-	save_state()
-
-	return shell.OK
-}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"seva/lib/shell"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	OK = iota
+	ERROR
+)
+
+type Event_Signature struct {
+	// Integer type is index of signature in the state array.
+	Type_Name string `json:"type_name"`
+	// Values can be:
+	//   - int
+	//   - string
+	//   - float
+	//   - array
+	//   - dict
+	//   - bool
+	Fields map[string]string `json:"fields"`
+	// Values offered by guided entry, see `addevent -i`, by field keys. Other
+	// ways of adding events do not fill them.
+	Defaults map[string]string `json:"defaults,omitempty"`
+	// Deprecated signatures accept no new events, existing ones stay
+	// readable.
+	Deprecated bool `json:"deprecated,omitempty"`
+	// Deleted signature keeps it's slot, so integer types of the following
+	// signatures do not change.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+type Event struct {
+	// Sequence number of an event within it's domain, starting from 1. Events
+	// stored before sequences were introduced are numbered by their position
+	// at reading.
+	Seq int `json:"seq"`
+	// Time of event injection, increasing within a domain.
+	Created_Ms int64 `json:"created_ms"`
+	// Time of events stored before milliseconds were introduced, kept since
+	// it's hashed.
+	Created_Sec int `json:"created_sec,omitempty"`
+	// Integer type of an event. Each project has own unsigned set of types,
+	// starting from 1.
+	Type   int               `json:"type"`
+	Fields map[string]string `json:"fields"`
+	// Hex SHA-256 of the event chained to the hash of the previous one.
+	Hash string `json:"hash"`
+}
+
+// Events of the active segments by their domains, sealed segments are read
+// from disk when needed. Every domain has an entry, even an empty one.
+var events = map[string][]*Event{}
+
+// Domains by their list of event signatures
+var signatures = map[string][]*Event_Signature{}
+
+// Signature files by their domains
+var sigfiles = map[string]*os.File{}
+
+// Guards state in server mode, where requests are handled concurrently.
+var state_lock sync.Mutex
+
+func read_event_state() int {
+	dir := bone.Userdir("events")
+	bone.Mkdir(dir)
+	files, er := os.ReadDir(dir)
+	if er != nil {
+		bone.Log_Error("During state reading, cannot read userdir, error: %s", er)
+		return ERROR
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			er := segment_read_domain(file.Name())
+			if er != nil {
+				bone.Log_Error("%s", er)
+				return ERROR
+			}
+			continue
+		}
+		// Domains stored as a single file are converted to segments.
+		if filepath.Ext(file.Name()) == ".json" {
+			domain, _ := strings.CutSuffix(file.Name(), filepath.Ext(file.Name()))
+			er := segment_migrate(domain, filepath.Join(dir, file.Name()))
+			if er != nil {
+				bone.Log_Error("%s", er)
+				return ERROR
+			}
+		}
+	}
+	return OK
+}
+
+func read_signature_state() int {
+	dir := bone.Userdir("signatures")
+	bone.Mkdir(dir)
+	files, er := os.ReadDir(dir)
+	if er != nil {
+		bone.Log_Error("During state reading, cannot read userdir, error: %s", er)
+		return ERROR
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			path := filepath.Join(dir, file.Name())
+			data, er := os.ReadFile(path)
+			if er != nil {
+				bone.Log_Error("During state reading, cannot read file '%s', error: %s", path, er)
+				return ERROR
+			}
+			domain, _ := strings.CutSuffix(file.Name(), filepath.Ext(file.Name()))
+			sigs := []*Event_Signature{}
+			er = json.Unmarshal(data, &sigs)
+			signatures[domain] = sigs
+			if er != nil {
+				bone.Log_Error("Cannot unmarshal file '%s', error: %s", file.Name(), er)
+				return ERROR
+			}
+		}
+	}
+	return OK
+}
+
+// Read all files in userdir and unmarshal them to state.
+func read_state() int {
+	e := read_signature_state()
+	if e != OK {
+		return e
+	}
+	e = read_event_state()
+	if e != OK {
+		return e
+	}
+	// Domains of an older format are read completely, the rest keep only
+	// their active segments in memory
+	for domain, index := range segment_indexes {
+		if index.Format >= segment_format {
+			continue
+		}
+		if chain_init(domain) {
+			bone.Log_Info("Chained existing events of domain '%s'", domain)
+		}
+		if migrate_event_times(domain) {
+			bone.Log_Info("Converted event times of domain '%s' to milliseconds", domain)
+		}
+		index.Format = segment_format
+		er := segment_rewrite(domain)
+		if er != nil {
+			bone.Log_Error("%s", er)
+			return ERROR
+		}
+	}
+
+	// Add "main" domain if does not exist
+	_, ok := signatures["main"]
+	if !ok {
+		signatures["main"] = []*Event_Signature{}
+		events["main"] = []*Event{}
+		save_state()
+	}
+
+	project_state()
+
+	return OK
+}
+
+// Returns event by it's sequence number, or nil if there is no such event.
+func find_event(domain string, seq int) (*Event, error) {
+	found, er := find_events(domain, []int{seq})
+	if er != nil {
+		return nil, er
+	}
+	return found[seq], nil
+}
+
+// Returns signature of an event, or nil if the event type is unknown.
+func find_event_signature(domain string, event *Event) *Event_Signature {
+	sigs := signatures[domain]
+	if event.Type < 1 || event.Type > len(sigs) {
+		return nil
+	}
+	return sigs[event.Type-1]
+}
+
+func next_seq(domain string) int {
+	evs := events[domain]
+	if len(evs) > 0 {
+		return evs[len(evs)-1].Seq + 1
+	}
+	// All events may be removed by retention, but sequences are never reused.
+	index, ok := segment_indexes[domain]
+	if ok && len(index.Segments) > 0 {
+		return index.Segments[len(index.Segments)-1].Last_Seq + 1
+	}
+	return 1
+}
+
+func save_state() {
+	for domain := range events {
+		segment_save(domain)
+	}
+
+	sigdir := bone.Userdir("signatures")
+	bone.Mkdir(sigdir)
+	for domain, sigs := range signatures {
+		data, er := json.MarshalIndent(sigs, "", "\t")
+		if er != nil {
+			bone.Log_Error("Error marshalling state to json for domain '%s'", domain)
+			return
+		}
+
+		f, ok := sigfiles[domain]
+		if !ok {
+			path := filepath.Join(sigdir, domain+".json")
+			f, er = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+			if er != nil {
+				bone.Log_Error("Cannot open data file for domain '%s' at path '%s'", domain, path)
+				continue
+			}
+			sigfiles[domain] = f
+		}
+
+		er = f.Truncate(0)
+		if er != nil {
+			bone.Log_Error("Cannot truncate file for domain '%s'", domain)
+			continue
+		}
+
+		_, er = f.Seek(0, 0)
+		if er != nil {
+			bone.Log_Error("Cannot seek file of domain '%s'", domain)
+			continue
+		}
+
+		_, er = f.Write(data)
+		if er != nil {
+			bone.Log_Error("Cannot write to a file for domain '%s'", domain)
+			continue
+		}
+	}
+}
+
+func create_server() *gin.Engine {
+	gin.DefaultWriter = bone.Log_Writer(bone.LOG_DEBUG)
+	gin.DefaultErrorWriter = bone.Log_Writer(bone.LOG_ERROR)
+	server := gin.New()
+	server.Use(rpc.Logger())
+	server.Use(gin.Recovery())
+	cors_configure()
+	bone.Config.Subscribe("server", cors_configure)
+	server.Use(cors_middleware)
+	server.Use(rpc.Auth(func() string {
+		return bone.Config.String("server", "token")
+	}))
+
+	server.POST("/Rpc/Domains/GetDomains", rpc_get_domains)
+	server.POST("/Rpc/Domains/GetDomainInfos", rpc_get_domain_infos)
+	server.POST("/Rpc/Domains/CreateDomain", rpc_create_domain)
+	server.POST("/Rpc/Domains/RenameDomain", rpc_rename_domain)
+	server.POST("/Rpc/Domains/DeleteDomain", rpc_delete_domain)
+	server.POST("/Rpc/Domains/CopyDomain", rpc_copy_domain)
+	server.POST("/Rpc/Domains/ForkDomain", rpc_fork_domain)
+	server.POST("/Rpc/Templates/GetTemplates", rpc_get_templates)
+	server.POST("/Rpc/Templates/SaveTemplate", rpc_save_template)
+	server.POST("/Rpc/Sevent/GetSignatures", rpc_get_signatures)
+	server.POST("/Rpc/Sevent/GetSpecs", rpc_get_specs)
+	server.POST("/Rpc/Sevent/CreateSignature", rpc_create_signature)
+	server.POST("/Rpc/Sevent/CreateEvent", rpc_create_event)
+	server.POST("/Rpc/Sevent/GetEvents", rpc_get_events)
+	server.POST("/Rpc/Sevent/GetEvent", rpc_get_event)
+	server.POST("/Rpc/Sevent/WaitEvents", rpc_wait_events)
+	server.POST("/Rpc/Sevent/Search", rpc_search)
+	server.POST("/Rpc/Sevent/Verify", rpc_verify)
+	server.POST("/Rpc/Shell/GetCommands", shell_guard, rpc_get_commands)
+	server.POST("/Rpc/Shell/Execute", shell_guard, rpc_execute)
+	server.POST("/Rpc/Shell/Confirm", shell_guard, rpc_confirm)
+
+	return server
+}
+
+// Shell endpoints run any command, like `domain delete`, so they are served
+// only to clients authorized by a token.
+func shell_guard(c *gin.Context) {
+	if bone.Config.String("server", "token") == "" {
+		rpc.Fail(c, ERR_SHELL_DISABLED)
+	}
+}
+
+func deinit() {
+	bone.Log_Close()
+	for _, f := range sigfiles {
+		f.Close()
+	}
+	for k := range sigfiles {
+		delete(sigfiles, k)
+	}
+}
+
+// Writes to a temporary file first and then moves it to the path, so a crash
+// does not leave a partially written file.
+func write_file_atomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	er := os.WriteFile(tmp, data, 0644)
+	if er != nil {
+		return ERR_STORAGE.With("action", "write", "path", tmp).Wrap(er)
+	}
+	er = os.Rename(tmp, path)
+	if er != nil {
+		os.Remove(tmp)
+		return ERR_STORAGE.With("action", "move", "path", tmp).Wrap(er)
+	}
+	return nil
+}
+
+// Releases resources and exits, since deferred calls are not run by
+// `os.Exit`.
+func exit(code int) {
+	deinit()
+	os.Exit(code)
+}
+
+func main() {
+	defer deinit()
+
+	shell_enabled := flag.Bool("shell", false, "Enables shell mode.")
+	command := flag.String("c", "", "Executes a shell command and exits.")
+	script := flag.String("f", "", "Executes shell commands from a file and exits, `-` reads stdin.")
+	remote := flag.String("remote", "", "Runs the shell against a server at the `url` instead of the local state.")
+	token := flag.String("token", "", "Token for the remote server, `[remote] token` of the config by default.")
+	bone.Init("seva")
+	bone.Log_Colors = shell.Is_Terminal(int(os.Stdout.Fd()))
+	if config_init() != OK {
+		exit(ERROR)
+		return
+	}
+	i18n_init()
+
+	shell.Init()
+	register_commands()
+
+	if *remote != "" {
+		if *token == "" {
+			*token = bone.Config.String("remote", "token")
+		}
+		remote_init(*remote, *token)
+		shell.Set_Domain("main")
+	} else {
+		e := read_state()
+		if e != OK {
+			bone.Log_Error("During state reading, an error occurred: %d", e)
+			exit(e)
+			return
+		}
+	}
+
+	// Commands piped without a mode flag run as a script instead of starting
+	// the server
+	if !*shell_enabled && *command == "" && *script == "" && *remote == "" && stdin_piped() {
+		*script = "-"
+	}
+	if *shell_enabled || *command != "" || *script != "" || *remote != "" {
+		if *remote == "" {
+			domain := bone.Config.String("main", "domain")
+			if _, ok := signatures[domain]; !ok {
+				domain = "main"
+			}
+			shell.Set_Domain(domain)
+			save_state()
+		}
+
+		if *command != "" {
+			exit(shell.Execute(*command))
+			return
+		}
+		if *script == "-" {
+			exit(shell.Run_Batch(os.Stdin))
+			return
+		}
+		if *script != "" {
+			f, er := os.Open(*script)
+			if er != nil {
+				bone.Log_Error("Cannot open script '%s', error: %s", *script, er)
+				exit(ERROR)
+				return
+			}
+			e := shell.Run_Batch(f)
+			f.Close()
+			exit(e)
+			return
+		}
+		bone.Config.Watch(config_poll)
+		exit(shell.Run())
+		return
+	}
+
+	bone.Config.Watch(config_poll)
+	go retention_loop()
+
+	server := create_server()
+	server.Run("0.0.0.0:3000")
+}
+
+// Reports whether stdin is a pipe or a file. Terminals and devices like
+// `/dev/null`, which services are started with, are not.
+func stdin_piped() bool {
+	info, er := os.Stdin.Stat()
+	if er != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
+func register_commands() {
+	shell.Set_Command(&shell.Command{
+		Name:        "setdomain",
+		Description: "Switches the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "DOMAIN", Type: shell.ARG_STRING, Description: "Domain name or @N of the last listing, `main` by default."},
+		},
+		Handler: shell_set_domain,
+		Complete: func(tokens []string) []shell.Completion {
+			if len(tokens) > 1 {
+				return nil
+			}
+			r := []shell.Completion{}
+			for _, domain := range sorted_domains() {
+				r = append(r, shell.Completion{Value: domain})
+			}
+			return r
+		},
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "addevent",
+		Aliases:     []string{"ae"},
+		Description: "Appends an event to the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TYPE key=value", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "Event type followed by it's fields."},
+			{Key: "-i", Type: shell.ARG_BOOL, Description: "Asks for each field which is not given."},
+		},
+		Handler:  shell_add_event,
+		Complete: complete_event_fields,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "addsig",
+		Aliases:     []string{"as"},
+		Description: "Adds an event signature to the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TYPE key=type", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "Event type followed by it's field types: int, string, float, bool, array or dict. Type may be followed by a default value offered by `addevent -i`, e.g. `paid=bool:false`."},
+		},
+		Handler: shell_add_signature,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "search",
+		Description: "Searches events by words in their string fields.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TERM", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "Words to search."},
+			{Key: "-n", Type: shell.ARG_INT, Description: "Maximum amount of results, 20 by default."},
+		},
+		Handler: shell_search,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "snapshot",
+		Description: "Manages snapshots of the current domain's projections.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "take|list|verify|prune", Type: shell.ARG_STRING, Description: "Action, `take` by default."},
+			{Key: "-keep", Type: shell.ARG_INT, Description: "Amount of the newest snapshots kept by prune."},
+		},
+		Handler: shell_snapshot,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "segments",
+		Description: "Manages segments of the current domain's event log.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "list|seal|archive|compact", Type: shell.ARG_STRING, Description: "Action, `list` by default."},
+		},
+		Handler: shell_segments,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "verify",
+		Description: "Verifies the hash chain of the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "-checkpoint", Type: shell.ARG_BOOL, Description: "Signs a checkpoint of the chain head before verification."},
+		},
+		Handler: shell_verify,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "prune",
+		Description: "Applies the retention policy to the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "-dry", Type: shell.ARG_BOOL, Description: "Only reports amount of expired events."},
+			{Key: "-yes", Type: shell.ARG_BOOL, Description: "Skips confirmation."},
+		},
+		Handler: shell_prune,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "domain",
+		Description: "Creates, renames, deletes, copies or forks domains.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "ACTION DOMAIN [TARGET]", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "One of: create NAME, rename OLD NEW, delete NAME, copy SRC DST, fork SRC DST."},
+			{Key: "-template", Name: "TEMPLATE", Type: shell.ARG_STRING, Description: "Template to take signatures of a created domain from, see `template list`."},
+			{Key: "-as-of", Name: "POINT", Type: shell.ARG_STRING, Description: "Last event copied by fork, as #SEQ or time like 2026-01-02 15:04, -2h or yesterday."},
+			{Key: "-yes", Type: shell.ARG_BOOL, Description: "Skips confirmation."},
+		},
+		Handler:  shell_domain,
+		Complete: complete_domain,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "delsig",
+		Description: "Deletes a signature which has no events.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TYPE", Type: shell.ARG_STRING, Required: true, Description: "Event type or @N of the last listing."},
+			{Key: "-yes", Type: shell.ARG_BOOL, Description: "Skips confirmation."},
+		},
+		Handler: shell_delete_signature,
+		Complete: func(tokens []string) []shell.Completion {
+			if len(tokens) > 1 {
+				return nil
+			}
+			return complete_types()
+		},
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "deprecate",
+		Description: "Deprecates a signature, so it accepts no new events.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TYPE", Type: shell.ARG_STRING, Required: true, Description: "Event type or @N of the last listing."},
+			{Key: "-yes", Type: shell.ARG_BOOL, Description: "Skips confirmation."},
+		},
+		Handler: shell_deprecate_signature,
+		Complete: func(tokens []string) []shell.Completion {
+			if len(tokens) > 1 {
+				return nil
+			}
+			return complete_types()
+		},
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "tail",
+		Description: "Prints recent events of the current domain and follows new ones.",
+		Args: []*shell.Arg_Spec{
+			{Key: "-n", Type: shell.ARG_INT, Description: "Amount of recent events, 10 by default."},
+			{Key: "-type", Name: "TYPE", Type: shell.ARG_STRING, Description: "Follows only events of the type."},
+			{Key: "-where", Name: "key=value", Type: shell.ARG_STRING, Variadic: true, Description: "Field predicates which all have to match, operators: = != > < >= <= ~"},
+		},
+		Handler: shell_tail,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "config",
+		Description: "Lists config keys with their values, or sets one.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "[SECTION.KEY VALUE]", Type: shell.ARG_STRING, Variadic: true, Description: "Key to set, e.g. `snapshot.keep 5`."},
+		},
+		Handler:  shell_config,
+		Complete: complete_config,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "template",
+		Description: "Saves signatures of the current domain as a template, or lists templates.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "save NAME | list", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "Action and template name."},
+			{Key: "-yes", Type: shell.ARG_BOOL, Description: "Skips confirmation of overwriting."},
+		},
+		Handler:  shell_template,
+		Complete: complete_template,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "replay",
+		Description: "Replays events of a domain through filters and mappings into another one.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "SOURCE TARGET", Type: shell.ARG_STRING, Required: true, Variadic: true, Description: "Source domain and target one, which is created if missing."},
+			{Key: "-type", Name: "TYPE", Type: shell.ARG_STRING, Description: "Replays only events of the type."},
+			{Key: "-where", Name: "key=value", Type: shell.ARG_STRING, Variadic: true, Description: "Field predicates which all have to match, operators: = != > < >= <= ~"},
+			{Key: "-rename-type", Name: "OLD=NEW", Type: shell.ARG_STRING, Variadic: true, Description: "Renames event types."},
+			{Key: "-rename-field", Name: "[TYPE.]old=new", Type: shell.ARG_STRING, Variadic: true, Description: "Renames fields of a type, or of any type without prefix."},
+			{Key: "-drop-field", Name: "[TYPE.]key", Type: shell.ARG_STRING, Variadic: true, Description: "Drops fields of a type, or of any type without prefix."},
+			{Key: "-convert", Name: "[TYPE.]key=type", Type: shell.ARG_STRING, Variadic: true, Description: "Converts fields to another type."},
+			{Key: "-dry-run", Type: shell.ARG_BOOL, Description: "Reports the changes without writing anything."},
+		},
+		Handler:  shell_replay,
+		Complete: complete_replay,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "domains",
+		Description: "Lists domains.",
+		Handler:     shell_domains,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "sigs",
+		Description: "Lists signatures of the current domain.",
+		Handler:     shell_sigs,
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "sig",
+		Description: "Shows fields of a signature.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "TYPE", Type: shell.ARG_STRING, Required: true, Description: "Event type or @N of the last listing."},
+		},
+		Handler: shell_sig,
+		Complete: func(tokens []string) []shell.Completion {
+			if len(tokens) > 1 {
+				return nil
+			}
+			return complete_types()
+		},
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "events",
+		Description: "Lists the newest events of the current domain.",
+		Args: []*shell.Arg_Spec{
+			{Key: "-n", Type: shell.ARG_INT, Description: "Maximum amount of events, 20 by default."},
+			{Key: "-type", Name: "TYPE", Type: shell.ARG_STRING, Description: "Lists only events of the type."},
+			{Key: "-time", Name: "RANGE", Type: shell.ARG_STRING, Description: "Lists only events within the time range, e.g. `yesterday`, `-2h..` or `2026-01..2026-03`."},
+		},
+		Handler: shell_events,
+		Complete: func(tokens []string) []shell.Completion {
+			if len(tokens) > 1 && tokens[len(tokens)-2] == "-type" {
+				return complete_types()
+			}
+			return nil
+		},
+	})
+	shell.Set_Command(&shell.Command{
+		Name:        "event",
+		Description: "Shows an event.",
+		Args: []*shell.Arg_Spec{
+			{Key: "_", Name: "SEQ", Type: shell.ARG_STRING, Required: true, Description: "Event sequence or @N of the last listing."},
+		},
+		Handler: shell_event,
+	})
+}
+
+func complete_types() []shell.Completion {
+	r := []shell.Completion{}
+	for _, signature := range signatures[shell.Get_Domain()] {
+		if signature.Deleted {
+			continue
+		}
+		r = append(r, shell.Completion{Value: signature.Type_Name, Hint: fmt.Sprintf("%d fields", len(signature.Fields))})
+	}
+	return r
+}
+
+// Completes type name first, then names of the type's fields which are not
+// yet given.
+func complete_event_fields(tokens []string) []shell.Completion {
+	if len(tokens) <= 1 {
+		return complete_types()
+	}
+	_, signature := find_signature(shell.Get_Domain(), tokens[0])
+	if signature == nil {
+		return nil
+	}
+	given := map[string]bool{}
+	for _, token := range tokens[1 : len(tokens)-1] {
+		key, _, _ := shell.Split_Pair(token)
+		given[key] = true
+	}
+	r := []shell.Completion{}
+	for key, value := range signature.Fields {
+		if !given[key] {
+			r = append(r, shell.Completion{Value: key + "=", Hint: value})
+		}
+	}
+	return r
+}
+
+func rpc_get_commands(c *gin.Context) {
+	rpc.Ok(c, shell.Get_Commands())
+}
+
+type Rpc_Execute_Args struct {
+	Input  string
+	Domain string
+	// Key chosen by the client, e.g. per terminal, which keeps `@N` hooks and
+	// pending questions apart from other clients.
+	Session string
+}
+
+// Runs a shell command for the web terminal. Failed commands are still
+// reported with `Ok`, their errors are in the output along with the code.
+func rpc_execute(c *gin.Context) {
+	var args Rpc_Execute_Args
+	if !bind_args(c, &args) {
+		return
+	}
+	if args.Domain == "" {
+		args.Domain = "main"
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	if !require_domain(c, args.Domain) {
+		return
+	}
+	rpc.Ok(c, shell.Execute_Captured(args.Input, args.Session, args.Domain, rpc.Locale(c)))
+}
+
+type Rpc_Confirm_Args struct {
+	// Token of the confirmation returned by `Shell/Execute`.
+	Token  string
+	Answer bool
+	// Session the command was executed in.
+	Session string
+}
+
+func rpc_confirm(c *gin.Context) {
+	var args Rpc_Confirm_Args
+	if !bind_args(c, &args) {
+		return
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	rpc.Ok(c, shell.Confirm_Captured(args.Session, args.Token, args.Answer))
+}
+
+func shell_add_signature(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	if len(parts) == 0 {
+		c.Error("Specify at least event type")
+		return shell.ERROR
+	}
+	fields, er := parse_pairs(parts[1:])
+	if er == nil {
+		_, er = add_signature(shell.Get_Domain(), parts[0], fields)
+	}
+	if er != nil {
+		return c.Fail(er)
+	}
+	return shell.OK
+}
+
+func shell_add_event(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	if len(parts) == 0 {
+		c.Error("Specify at least event type")
+		return shell.ERROR
+	}
+	fields, er := parse_pairs(parts[1:])
+	if er != nil {
+		return c.Fail(er)
+	}
+	domain := shell.Get_Domain()
+
+	if c.Arg_Bool("-i", false) {
+		_, signature := find_signature(domain, parts[0])
+		if signature == nil {
+			return c.Fail(ERR_SIGNATURE_NOT_FOUND.With("type", parts[0]))
+		}
+		return guide_event(c, signature, fields, func(fields map[string]string) int {
+			event, er := add_event(domain, signature.Type_Name, fields)
+			if er != nil {
+				return c.Fail(er)
+			}
+			c.Message("Event #%d added", event.Seq)
+			return shell.OK
+		})
+	}
+
+	_, er = add_event(domain, parts[0], fields)
+	if er != nil {
+		return c.Fail(er)
+	}
+	return shell.OK
+}
+
+// Returns type name of the `@N` reference to a listed signature or the
+// argument itself.
+func signature_arg(arg string) string {
+	signature, type_name := hooked_signature(arg)
+	if signature != nil {
+		return signature.Type_Name
+	}
+	return type_name
+}
+
+func shell_delete_signature(c *shell.Command_Context) int {
+	type_name := strings.ToUpper(signature_arg(c.Arg_String("_", "")))
+	domain := shell.Get_Domain()
+	text := fmt.Sprintf("Delete signature '%s' of domain '%s'?", type_name, domain)
+	return c.Confirm(text, func() int {
+		er := delete_signature(domain, type_name)
+		if er != nil {
+			return c.Fail(er)
+		}
+		c.Message("Signature '%s' deleted", type_name)
+		return shell.OK
+	})
+}
+
+func shell_deprecate_signature(c *shell.Command_Context) int {
+	type_name := strings.ToUpper(signature_arg(c.Arg_String("_", "")))
+	domain := shell.Get_Domain()
+	text := fmt.Sprintf("Deprecate signature '%s' of domain '%s'? It will accept no new events.", type_name, domain)
+	return c.Confirm(text, func() int {
+		er := deprecate_signature(domain, type_name)
+		if er != nil {
+			return c.Fail(er)
+		}
+		c.Message("Signature '%s' deprecated", type_name)
+		return shell.OK
+	})
+}
+
+func shell_set_domain(c *shell.Command_Context) int {
+	domain := c.Arg_String("_", "main")
+	hook, ok := shell.Resolve_Hook(domain)
+	if ok {
+		domain, _ = hook.(string)
+	}
+
+	if shell.Get_Domain() == domain {
+		return shell.OK
+	}
+
+	_, ok = signatures[domain]
+	if !ok {
+		c.Fail(ERR_DOMAIN_NOT_FOUND.With("domain", domain))
+		c.Message("Create it with `domain create %s`", domain)
+		return shell.ERROR
+	}
+	shell.Set_Domain(domain)
+
+	// Cache domain in config for future logins
+	if !shell.Is_Captured() {
+		bone.Config.Write_String("main", "domain", domain)
+	}
+	return shell.OK
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"seva/lib/bone"
+	"testing"
+)
+
+// State is read from the testing userdir, which `bone.Init` clears, and
+// configuration from `testing.cfg` of the repository root.
+func TestMain(m *testing.M) {
+	if bone.Init("seva") != 0 || config_init() != OK {
+		os.Exit(1)
+	}
+	i18n_init()
+	register_commands()
+	os.Exit(m.Run())
+}
+
+// Creates an empty domain, deleting the one left by a previous test.
+func test_domain(domain string) {
+	_, ok := signatures[domain]
+	if ok {
+		bone.Assert(delete_domain(domain) == nil)
+	}
+	bone.Assert(create_domain(domain) == nil)
+	project_reset(domain)
+}
+
+// Creates an empty domain with a `NOTE` signature of a single text field and
+// adds a note for each of the texts.
+func test_notes(domain string, texts ...string) []*Event {
+	test_domain(domain)
+	_, er := add_signature(domain, "NOTE", map[string]string{"text": "string"})
+	bone.Assert(er == nil)
+	evs := []*Event{}
+	for _, text := range texts {
+		evs = append(evs, test_event(domain, "NOTE", map[string]string{"text": text}))
+	}
+	return evs
+}
+
+func test_event(domain string, type_name string, fields map[string]string) *Event {
+	event, er := add_event(domain, type_name, fields)
+	bone.Assert(er == nil)
+	return event
+}
+
+func test_search(domain string, query string, limit int) []*Search_Hit {
+	hits, er := search(domain, query, limit)
+	bone.Assert(er == nil)
+	return hits
+}
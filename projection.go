@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"seva/lib/bone"
+)
+
+// Folds a domain's events into a derived state.
+type Projection struct {
+	// Unique name, used as a key in snapshots.
+	Name string
+	// Drops the derived state of a domain.
+	Reset func(domain string)
+	// Forgets a domain completely.
+	Drop func(domain string)
+	// Signature is nil if the event type is unknown.
+	Apply func(domain string, event *Event, signature *Event_Signature)
+	// Returns the derived state of a domain to be stored in a snapshot.
+	Save func(domain string) any
+	Load func(domain string, data json.RawMessage) error
+	// Reports whether the projection derived anything from the domain, so
+	// templates record only projections their domains rely on.
+	Used func(domain string) bool
+}
+
+var projections = []*Projection{
+	search_projection,
+	counts_projection,
+}
+
+// Amounts of stored events by their types, by domains.
+var event_counts = map[string]map[int]int{}
+
+var counts_projection = &Projection{
+	Name: "counts",
+	Reset: func(domain string) {
+		event_counts[domain] = map[int]int{}
+	},
+	Drop: func(domain string) {
+		delete(event_counts, domain)
+	},
+	Apply: func(domain string, event *Event, signature *Event_Signature) {
+		counts, ok := event_counts[domain]
+		if !ok {
+			counts = map[int]int{}
+			event_counts[domain] = counts
+		}
+		counts[event.Type]++
+	},
+	Save: func(domain string) any {
+		return event_counts[domain]
+	},
+	Load: func(domain string, data json.RawMessage) error {
+		counts := map[int]int{}
+		er := json.Unmarshal(data, &counts)
+		if er != nil {
+			return ERR_DECODE.With("what", fmt.Sprintf("event counts of domain '%s'", domain)).Wrap(er)
+		}
+		event_counts[domain] = counts
+		return nil
+	},
+}
+
+// Sequence of the last event applied to projections, by domains.
+var projected_seq = map[string]int{}
+
+func project_reset(domain string) {
+	for _, p := range projections {
+		p.Reset(domain)
+	}
+	projected_seq[domain] = 0
+}
+
+func project_drop(domain string) {
+	for _, p := range projections {
+		p.Drop(domain)
+	}
+	delete(projected_seq, domain)
+}
+
+func project_event(domain string, event *Event) {
+	project_apply(domain, event, find_event_signature(domain, event))
+}
+
+// Signature is passed explicitly, so events can be projected under another
+// domain name than they are stored.
+func project_apply(domain string, event *Event, signature *Event_Signature) {
+	if event.Seq <= projected_seq[domain] {
+		return
+	}
+	for _, p := range projections {
+		p.Apply(domain, event, signature)
+	}
+	projected_seq[domain] = event.Seq
+}
+
+// Applies events stored after the last projected one. Only they are read, so
+// segments covered by a restored snapshot are not touched.
+func project_catch_up(domain string) error {
+	evs, er := read_events_range(domain, projected_seq[domain]+1, 0)
+	if er != nil {
+		return er
+	}
+	for _, event := range evs {
+		project_event(domain, event)
+	}
+	return nil
+}
+
+// Restores projections of a domain from it's latest snapshot and replays the
+// events stored after it.
+func project_restore(domain string) error {
+	project_reset(domain)
+	snapshot_restore(domain)
+	return project_catch_up(domain)
+}
+
+func project_state() {
+	for domain := range events {
+		er := project_restore(domain)
+		if er != nil {
+			bone.Log_Error("%s", er)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"seva/lib/shell"
+	"strings"
+	"time"
+)
+
+// Remote mode runs the shell against a server's HTTP API instead of the local
+// state. The current domain is kept in the session only, so switching it does
+// not affect other clients or the local config.
+
+var remote_url string
+var remote_token string
+
+var remote_client = &http.Client{Timeout: 30 * time.Second}
+
+type Remote_Envelope struct {
+	Body  json.RawMessage
+	Error *rpc.Error_Body
+}
+
+// Restores a bone error from the response, so it's rendered like a local
+// one.
+func remote_error(body *rpc.Error_Body) error {
+	if body.Key == "" {
+		return ERR_REMOTE.With("message", body.Message)
+	}
+	e := &bone.Error{Code: body.Code, Key: body.Key, Details: body.Details}
+	if body.Cause != "" {
+		e.Cause = errors.New(body.Cause)
+	}
+	return e
+}
+
+// Posts arguments to the endpoint like `Sevent/GetEvents` and decodes the
+// response body into the result, which may be nil.
+func remote_call(path string, args any, result any) error {
+	return remote_request(context.Background(), path, args, result)
+}
+
+// Same as `remote_call`, but stops once the context is cancelled.
+func remote_request(ctx context.Context, path string, args any, result any) error {
+	data, er := json.Marshal(args)
+	if er != nil {
+		return ERR_ENCODE.With("what", "arguments for '"+path+"'").Wrap(er)
+	}
+	req, er := http.NewRequestWithContext(ctx, "POST", remote_url+"/Rpc/"+path, bytes.NewReader(data))
+	if er != nil {
+		return ERR_REMOTE_URL.With("url", remote_url).Wrap(er)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", bone.Get_Locale())
+	if remote_token != "" {
+		req.Header.Set("Authorization", "Bearer "+remote_token)
+	}
+
+	res, er := remote_client.Do(req)
+	if er != nil {
+		return ERR_REMOTE_UNREACHABLE.With("url", remote_url).Wrap(er)
+	}
+	defer res.Body.Close()
+
+	var envelope Remote_Envelope
+	er = json.NewDecoder(res.Body).Decode(&envelope)
+	if er != nil {
+		return ERR_REMOTE_RESPONSE.With("path", path, "status", res.Status)
+	}
+	if envelope.Error != nil {
+		return remote_error(envelope.Error)
+	}
+	if res.StatusCode != 200 {
+		return ERR_REMOTE_RESPONSE.With("path", path, "status", res.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	er = json.Unmarshal(envelope.Body, result)
+	if er != nil {
+		return ERR_DECODE.With("what", "response from '"+path+"'").Wrap(er)
+	}
+	return nil
+}
+
+// Returns signatures placed by their integer types, like in the local state.
+func remote_signatures(domain string) ([]*Event_Signature, error) {
+	return remote_signatures_ctx(context.Background(), domain)
+}
+
+func remote_signatures_ctx(ctx context.Context, domain string) ([]*Event_Signature, error) {
+	var infos []*Signature_Info
+	er := remote_request(ctx, "Sevent/GetSignatures", &Rpc_Domain_Args{Domain: domain}, &infos)
+	if er != nil {
+		return nil, er
+	}
+	sigs := []*Event_Signature{}
+	for _, info := range infos {
+		// Deleted signatures are not listed, but keep their slots
+		for len(sigs) < info.Id-1 {
+			sigs = append(sigs, &Event_Signature{Deleted: true})
+		}
+		sigs = append(sigs, info.Signature)
+	}
+	return sigs, nil
+}
+
+func remote_local_only(c *shell.Command_Context) int {
+	c.Error("Command '%s' is not available in remote mode", c.Command_Name)
+	return shell.ERROR
+}
+
+func remote_set_domain(c *shell.Command_Context) int {
+	domain := c.Arg_String("_", "main")
+	hook, ok := shell.Resolve_Hook(domain)
+	if ok {
+		domain, _ = hook.(string)
+	}
+	if shell.Get_Domain() == domain {
+		return shell.OK
+	}
+	var domains []string
+	er := remote_call("Domains/GetDomains", struct{}{}, &domains)
+	if er != nil {
+		return c.Fail(er)
+	}
+	for _, d := range domains {
+		if d == domain {
+			shell.Set_Domain(domain)
+			return shell.OK
+		}
+	}
+	c.Fail(ERR_DOMAIN_NOT_FOUND.With("domain", domain))
+	c.Message("Create it with `domain create %s`", domain)
+	return shell.ERROR
+}
+
+func remote_add_signature(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	if len(parts) == 0 {
+		c.Error("Specify at least event type")
+		return shell.ERROR
+	}
+	fields, er := parse_pairs(parts[1:])
+	if er != nil {
+		return c.Fail(er)
+	}
+	er = remote_call("Sevent/CreateSignature", &Rpc_Create_Signature_Args{
+		Domain:    shell.Get_Domain(),
+		EventType: parts[0],
+		Fields:    fields,
+	}, nil)
+	if er != nil {
+		return c.Fail(er)
+	}
+	return shell.OK
+}
+
+func remote_add_event(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	if len(parts) == 0 {
+		c.Error("Specify at least event type")
+		return shell.ERROR
+	}
+	fields, er := parse_pairs(parts[1:])
+	if er != nil {
+		return c.Fail(er)
+	}
+	domain := shell.Get_Domain()
+	submit := func(fields map[string]string) int {
+		body := map[string]any{}
+		for key, value := range fields {
+			body[key] = value
+		}
+		var event Event
+		er := remote_call("Sevent/CreateEvent", &Rpc_Create_Event_Args{
+			Domain:    domain,
+			EventType: parts[0],
+			Body:      body,
+		}, &event)
+		if er != nil {
+			return c.Fail(er)
+		}
+		if c.Arg_Bool("-i", false) {
+			c.Message("Event #%d added", event.Seq)
+		}
+		return shell.OK
+	}
+
+	if !c.Arg_Bool("-i", false) {
+		return submit(fields)
+	}
+	sigs, er := remote_signatures(domain)
+	if er != nil {
+		return c.Fail(er)
+	}
+	for _, signature := range sigs {
+		if signature.Type_Name == strings.ToUpper(parts[0]) && !signature.Deleted {
+			return guide_event(c, signature, fields, submit)
+		}
+	}
+	return c.Fail(ERR_SIGNATURE_NOT_FOUND.With("type", parts[0]))
+}
+
+func remote_domains(c *shell.Command_Context) int {
+	var infos []*Domain_Info
+	er := remote_call("Domains/GetDomainInfos", struct{}{}, &infos)
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_domains(c, infos)
+	return shell.OK
+}
+
+func remote_sigs(c *shell.Command_Context) int {
+	var infos []*Signature_Info
+	er := remote_call("Sevent/GetSignatures", &Rpc_Domain_Args{Domain: shell.Get_Domain()}, &infos)
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_signatures(c, infos)
+	return shell.OK
+}
+
+func remote_sig(c *shell.Command_Context) int {
+	arg := c.Arg_String("_", "")
+	signature, type_name := hooked_signature(arg)
+	if signature == nil && type_name != "" {
+		sigs, er := remote_signatures(shell.Get_Domain())
+		if er != nil {
+			return c.Fail(er)
+		}
+		for _, s := range sigs {
+			if s.Type_Name == strings.ToUpper(type_name) {
+				signature = s
+			}
+		}
+	}
+	if signature == nil {
+		return c.Fail(ERR_SIGNATURE_NOT_FOUND.With("type", arg))
+	}
+	render_signature(c, signature)
+	return shell.OK
+}
+
+func remote_events(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	sigs, er := remote_signatures(domain)
+	if er != nil {
+		return c.Fail(er)
+	}
+	var evs []*Event
+	er = remote_call("Sevent/GetEvents", &Rpc_Get_Events_Args{
+		Domain:    domain,
+		EventType: c.Arg_String("-type", ""),
+		Limit:     c.Arg_Int("-n", 20),
+		Time:      c.Arg_String("-time", ""),
+	}, &evs)
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_events(c, sigs, evs)
+	return shell.OK
+}
+
+func remote_event(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	event, seq, er := hooked_event(c.Arg_String("_", ""))
+	if er != nil {
+		return c.Fail(er)
+	}
+	sigs, er := remote_signatures(domain)
+	if er != nil {
+		return c.Fail(er)
+	}
+	if event == nil {
+		er = remote_call("Sevent/GetEvent", &Rpc_Get_Event_Args{Domain: domain, Seq: seq}, &event)
+		if er != nil {
+			return c.Fail(er)
+		}
+	}
+	render_event(c, sigs, event)
+	return shell.OK
+}
+
+func remote_search(c *shell.Command_Context) int {
+	query := c.Arg_String("_", "")
+	if query == "" {
+		c.Error("Specify search terms")
+		return shell.ERROR
+	}
+	domain := shell.Get_Domain()
+	sigs, er := remote_signatures(domain)
+	if er != nil {
+		return c.Fail(er)
+	}
+	var hits []*Search_Hit
+	er = remote_call("Sevent/Search", &Rpc_Search_Args{
+		Domain: domain,
+		Query:  query,
+		Limit:  c.Arg_Int("-n", 20),
+	}, &hits)
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_hits(c, sigs, hits)
+	return shell.OK
+}
+
+func remote_verify(c *shell.Command_Context) int {
+	if c.Arg_Bool("-checkpoint", false) {
+		return remote_local_only(c)
+	}
+	domain := shell.Get_Domain()
+	var report Chain_Report
+	er := remote_call("Sevent/Verify", &Rpc_Verify_Args{Domain: domain}, &report)
+	if er != nil {
+		return c.Fail(er)
+	}
+	return render_report(c, domain, &report)
+}
+
+// Replaces handlers of the registered commands with ones calling the server.
+func remote_init(url string, token string) {
+	remote_url = strings.TrimRight(url, "/")
+	remote_token = token
+	handlers := map[string]shell.Command_Handler{
+		"setdomain": remote_set_domain,
+		"addsig":    remote_add_signature,
+		"addevent":  remote_add_event,
+		"domains":   remote_domains,
+		"sigs":      remote_sigs,
+		"sig":       remote_sig,
+		"events":    remote_events,
+		"event":     remote_event,
+		"search":    remote_search,
+		"verify":    remote_verify,
+		"tail":      shell_tail,
+		"domain":    shell_domain,
+		"template":  shell_template,
+	}
+	for _, cmd := range shell.Get_Commands() {
+		if cmd.Name == "help" {
+			continue
+		}
+		handler, ok := handlers[cmd.Name]
+		if !ok {
+			handler = remote_local_only
+		}
+		cmd.Handler = handler
+		// Completion reads the local state, which is not loaded.
+		cmd.Complete = nil
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"seva/lib/shell"
+	"testing"
+)
+
+func init() {
+	shell.Set_Command(&shell.Command{Name: "remotesigs", Handler: remote_sigs})
+}
+
+// Serves a few endpoints the way the server's API does, requiring the token.
+func remote_test_server() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := func(status int, envelope any) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(envelope)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			reply(401, map[string]any{"Error": &rpc.Error_Body{Code: 1, Message: "Unauthorized"}})
+			return
+		}
+		switch r.URL.Path {
+		case "/Rpc/Domains/GetDomains":
+			reply(200, map[string]any{"Body": []string{"main", "work"}})
+		case "/Rpc/Sevent/GetSignatures":
+			reply(400, map[string]any{"Error": &rpc.Error_Body{
+				Code:    ERR_DOMAIN_NOT_FOUND.Code,
+				Message: "Cannot find domain 'gone'",
+				Key:     ERR_DOMAIN_NOT_FOUND.Key,
+				Details: map[string]any{"domain": "gone"},
+			}})
+		case "/Rpc/Sevent/GetEvents":
+			reply(502, map[string]any{"Body": nil})
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte("404 page not found"))
+		}
+	}))
+}
+
+func Test_remote_call_ok(t *testing.T) {
+	server := remote_test_server()
+	defer server.Close()
+	defer func() { remote_url, remote_token = "", "" }()
+	remote_url, remote_token = server.URL, "secret"
+
+	var domains []string
+	bone.Assert(remote_call("Domains/GetDomains", struct{}{}, &domains) == nil)
+	bone.Assert(len(domains) == 2 && domains[1] == "work")
+	// Body is skipped without a result
+	bone.Assert(remote_call("Domains/GetDomains", struct{}{}, nil) == nil)
+
+	// Typed server errors are restored
+	_, er := remote_signatures("gone")
+	bone.Assert(errors.Is(er, ERR_DOMAIN_NOT_FOUND))
+	bone.Assert(er.(*bone.Error).Text("en") == "Cannot find domain 'gone'")
+
+	// Errors without a key keep the server's message
+	remote_token = "wrong"
+	er = remote_call("Domains/GetDomains", struct{}{}, &domains)
+	bone.Assert(errors.Is(er, ERR_REMOTE))
+	bone.Assert(er.(*bone.Error).Text("en") == "Server failed: Unauthorized")
+	remote_token = "secret"
+
+	// Non-200 responses fail, with or without an envelope
+	er = remote_call("Sevent/GetEvents", struct{}{}, nil)
+	bone.Assert(errors.Is(er, ERR_REMOTE_RESPONSE))
+	er = remote_call("Sevent/Missing", struct{}{}, nil)
+	bone.Assert(errors.Is(er, ERR_REMOTE_RESPONSE))
+
+	server.Close()
+	er = remote_call("Domains/GetDomains", struct{}{}, &domains)
+	bone.Assert(errors.Is(er, ERR_REMOTE_UNREACHABLE))
+}
+
+func Test_remote_handler_error(t *testing.T) {
+	server := remote_test_server()
+	defer server.Close()
+	defer func() { remote_url, remote_token = "", "" }()
+	remote_url, remote_token = server.URL, "secret"
+
+	// Server errors are rendered by the handler with their code
+	r := shell.Execute_Captured("remotesigs", "", "gone", "en")
+	bone.Assert(r.Code == shell.ERROR && len(r.Output) == 1)
+	bone.Assert(r.Output[0].Kind == shell.OUTPUT_ERROR && r.Output[0].Code == ERR_DOMAIN_NOT_FOUND.Code)
+	bone.Assert(r.Output[0].Text == "Cannot find domain 'gone'")
+}
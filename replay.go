@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"seva/lib/shell"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transformations `replay` applies to events passed the filter. Field maps
+// are keyed by source type name, where the empty name applies to any type.
+// Field keys are the source ones.
+type Replay_Mapping struct {
+	// Target type names by source ones.
+	Types    map[string]string
+	Renames  map[string]map[string]string
+	Drops    map[string]map[string]bool
+	Converts map[string]map[string]string
+}
+
+// Event of the source domain as it would be appended to the target one.
+type replay_step struct {
+	source    *Event
+	type_name string
+	fields    map[string]string
+	changes   []string
+}
+
+type Replay_Plan struct {
+	// Target signatures by type name, existing ones included.
+	Signatures map[string]*Event_Signature
+	// Type names of signatures the target domain doesn't have yet, in the
+	// order they are added.
+	Created []string
+	// Source type names by target ones.
+	Sources map[string][]string
+	steps   []*replay_step
+	Skipped int
+}
+
+// Parses `[TYPE.]key=value` specs, or `[TYPE.]key` if `has_value` is false.
+func parse_field_specs(specs []string, has_value bool) (map[string]map[string]string, error) {
+	r := map[string]map[string]string{}
+	for _, spec := range specs {
+		target, value := spec, ""
+		if has_value {
+			var ok bool
+			target, value, ok = shell.Split_Pair(spec)
+			if !ok || value == "" {
+				return nil, ERR_INVALID_MAPPING.With("value", spec, "expected", "[TYPE.]key=value")
+			}
+		}
+		type_name, key, scoped := strings.Cut(target, ".")
+		if !scoped {
+			type_name, key = "", target
+		}
+		if key == "" {
+			return nil, ERR_MAPPING_KEY.With("value", spec)
+		}
+		type_name = strings.ToUpper(type_name)
+		if r[type_name] == nil {
+			r[type_name] = map[string]string{}
+		}
+		r[type_name][key] = value
+	}
+	return r, nil
+}
+
+func parse_mapping(rename_types []string, rename_fields []string, drop_fields []string, converts []string) (*Replay_Mapping, error) {
+	m := &Replay_Mapping{Types: map[string]string{}, Drops: map[string]map[string]bool{}}
+	for _, spec := range rename_types {
+		old, new, ok := shell.Split_Pair(spec)
+		if !ok || old == "" || new == "" {
+			return nil, ERR_INVALID_MAPPING.With("value", spec, "expected", "OLD=NEW")
+		}
+		m.Types[strings.ToUpper(old)] = strings.ToUpper(new)
+	}
+	var er error
+	m.Renames, er = parse_field_specs(rename_fields, true)
+	if er != nil {
+		return nil, er
+	}
+	drops, er := parse_field_specs(drop_fields, false)
+	if er != nil {
+		return nil, er
+	}
+	for type_name, keys := range drops {
+		m.Drops[type_name] = map[string]bool{}
+		for key := range keys {
+			m.Drops[type_name][key] = true
+		}
+	}
+	m.Converts, er = parse_field_specs(converts, true)
+	if er != nil {
+		return nil, er
+	}
+	for _, keys := range m.Converts {
+		for key, field_type := range keys {
+			if !slices.Contains(field_types, field_type) {
+				return nil, ERR_CONVERT_TYPE.With("field_type", field_type, "field", key)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Looks a field mapping up for the type first, then for any type.
+func (m *Replay_Mapping) field(specs map[string]map[string]string, type_name string, key string) (string, bool) {
+	value, ok := specs[type_name][key]
+	if ok {
+		return value, true
+	}
+	value, ok = specs[""][key]
+	return value, ok
+}
+
+func (m *Replay_Mapping) dropped(type_name string, key string) bool {
+	return m.Drops[type_name][key] || m.Drops[""][key]
+}
+
+func (m *Replay_Mapping) type_name(type_name string) string {
+	target, ok := m.Types[type_name]
+	if ok {
+		return target
+	}
+	return type_name
+}
+
+// Converts a stored value between field types. Floats become ints only if
+// they have no fraction, bools become ints as 1 and 0.
+func convert_value(type_name string, key string, value string, from string, to string) (string, error) {
+	if from == to || to == "string" || to == "array" || to == "dict" {
+		return value, nil
+	}
+	switch {
+	case to == "int" && from == "float":
+		f, er := strconv.ParseFloat(value, 64)
+		if er == nil && f == float64(int64(f)) {
+			return strconv.FormatInt(int64(f), 10), nil
+		}
+	case to == "int" && from == "bool":
+		if value == "true" || value == "1" {
+			return "1", nil
+		}
+		return "0", nil
+	case to == "bool" && from == "int":
+		if value == "0" || value == "1" {
+			return map[string]string{"0": "false", "1": "true"}[value], nil
+		}
+	}
+	er := validate_field(type_name, key, to, value)
+	if er != nil {
+		return "", er
+	}
+	return value, nil
+}
+
+// Maps a source signature to the target one.
+func (m *Replay_Mapping) signature(sig *Event_Signature) (*Event_Signature, error) {
+	target := &Event_Signature{Type_Name: m.type_name(sig.Type_Name), Fields: map[string]string{}}
+	for key, field_type := range sig.Fields {
+		if m.dropped(sig.Type_Name, key) {
+			continue
+		}
+		new_key := key
+		renamed, ok := m.field(m.Renames, sig.Type_Name, key)
+		if ok {
+			new_key = renamed
+		}
+		_, taken := target.Fields[new_key]
+		if taken {
+			return nil, ERR_MAPPING_COLLISION.With("type", sig.Type_Name, "field", new_key)
+		}
+		new_type := field_type
+		converted, ok := m.field(m.Converts, sig.Type_Name, key)
+		if ok {
+			new_type = converted
+		}
+		target.Fields[new_key] = new_type
+
+		default_, ok := sig.Defaults[key]
+		if !ok {
+			continue
+		}
+		// Default not convertible to the new type is dropped, events
+		// carry the filled value anyway
+		value, er := convert_value(sig.Type_Name, key, default_, field_type, new_type)
+		if er == nil {
+			if target.Defaults == nil {
+				target.Defaults = map[string]string{}
+			}
+			target.Defaults[new_key] = value
+		}
+	}
+	return target, nil
+}
+
+func same_fields(a *Event_Signature, b *Event_Signature) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for key, field_type := range a.Fields {
+		if b.Fields[key] != field_type {
+			return false
+		}
+	}
+	return true
+}
+
+// Maps events of the source domain without changing anything, so a failed
+// conversion stops the replay before the target is touched.
+func replay_plan(src string, dst string, filter *Event_Filter, m *Replay_Mapping) (*Replay_Plan, error) {
+	if src == dst {
+		return nil, ERR_REPLAY_SELF.With("domain", src)
+	}
+	_, ok := signatures[src]
+	if !ok {
+		return nil, ERR_DOMAIN_NOT_FOUND.With("domain", src)
+	}
+	if !shell.Is_Valid_Domain(dst) || dst == "" {
+		return nil, ERR_DOMAIN_INVALID.With("domain", dst)
+	}
+
+	plan := &Replay_Plan{Signatures: map[string]*Event_Signature{}, Sources: map[string][]string{}}
+	// Target signatures by source type names
+	mapped := map[string]*Event_Signature{}
+	evs, er := events_range(src, 1, 0)
+	if er != nil {
+		return nil, er
+	}
+	for _, event := range evs {
+		sig := find_event_signature(src, event)
+		if sig == nil || !filter.Match(sig.Type_Name, event) {
+			plan.Skipped++
+			continue
+		}
+
+		target, ok := mapped[sig.Type_Name]
+		if !ok {
+			target, er = m.signature(sig)
+			if er != nil {
+				return nil, er
+			}
+			known, ok := plan.Signatures[target.Type_Name]
+			if !ok {
+				_, known = find_signature(dst, target.Type_Name)
+				if known == nil {
+					plan.Created = append(plan.Created, target.Type_Name)
+				} else if known.Deprecated {
+					return nil, ERR_SIGNATURE_DEPRECATED.With("type", target.Type_Name)
+				}
+			}
+			if known != nil {
+				if !same_fields(known, target) {
+					return nil, ERR_REPLAY_FIELDS.With("type", target.Type_Name, "source", sig.Type_Name, "fields", format_fields(target.Fields), "existing", format_fields(known.Fields))
+				}
+				target = known
+			}
+			mapped[sig.Type_Name] = target
+			plan.Signatures[target.Type_Name] = target
+			plan.Sources[target.Type_Name] = append(plan.Sources[target.Type_Name], sig.Type_Name)
+		}
+
+		step := &replay_step{source: event, type_name: target.Type_Name, fields: map[string]string{}}
+		if target.Type_Name != sig.Type_Name {
+			step.changes = append(step.changes, fmt.Sprintf("type %s→%s", sig.Type_Name, target.Type_Name))
+		}
+		keys := sorted_keys(event.Fields)
+		for _, key := range keys {
+			value := event.Fields[key]
+			if m.dropped(sig.Type_Name, key) {
+				step.changes = append(step.changes, "-"+key)
+				continue
+			}
+			new_key := key
+			renamed, ok := m.field(m.Renames, sig.Type_Name, key)
+			if ok {
+				new_key = renamed
+				step.changes = append(step.changes, fmt.Sprintf("%s→%s", key, new_key))
+			}
+			new_value, er := convert_value(sig.Type_Name, key, value, sig.Fields[key], target.Fields[new_key])
+			if er != nil {
+				return nil, ERR_REPLAY_EVENT.With("seq", event.Seq).Wrap(er)
+			}
+			if new_value != value {
+				step.changes = append(step.changes, fmt.Sprintf("%s: %s→%s", new_key, value, new_value))
+			}
+			step.fields[new_key] = new_value
+		}
+		plan.steps = append(plan.steps, step)
+	}
+	return plan, nil
+}
+
+func sorted_keys(fields map[string]string) []string {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Appends planned events to the target domain, creating it and it's
+// missing signatures. Events get new sequences and hashes. They keep their
+// creation time unless it's not after the last event of the target, then
+// it's moved just past it, see `append_event`.
+func replay_apply(dst string, plan *Replay_Plan) error {
+	_, ok := signatures[dst]
+	if !ok {
+		er := create_domain(dst)
+		if er != nil {
+			return er
+		}
+	}
+	for _, type_name := range plan.Created {
+		signatures[dst] = append(signatures[dst], plan.Signatures[type_name])
+	}
+	for _, step := range plan.steps {
+		type_, _ := find_signature(dst, step.type_name)
+		append_event(dst, type_, step.fields, step.source.Created_Ms)
+	}
+	save_state()
+	return nil
+}
+
+func render_plan(c *shell.Command_Context, plan *Replay_Plan) {
+	rows := [][]string{}
+	type_names := []string{}
+	for type_name := range plan.Signatures {
+		type_names = append(type_names, type_name)
+	}
+	sort.Strings(type_names)
+	for _, type_name := range type_names {
+		state := "existing"
+		if slices.Contains(plan.Created, type_name) {
+			state = "new"
+		}
+		rows = append(rows, []string{type_name, strings.Join(plan.Sources[type_name], ", "), format_fields(plan.Signatures[type_name].Fields), state})
+	}
+	c.Table([]string{"TYPE", "FROM", "FIELDS", "STATE"}, rows)
+
+	rows = [][]string{}
+	for _, step := range plan.steps {
+		rows = append(rows, []string{fmt.Sprintf("%d", step.source.Seq), step.type_name, strings.Join(step.changes, ", ")})
+	}
+	c.Table([]string{"SEQ", "TYPE", "CHANGES"}, rows)
+}
+
+func shell_replay(c *shell.Command_Context) int {
+	domains := c.Arg_List("_")
+	if len(domains) != 2 {
+		c.Error("Expected source and target domains, got %d names", len(domains))
+		return shell.ERROR
+	}
+	src, dst := domains[0], domains[1]
+	filter, er := parse_filter(c.Arg_String("-type", ""), c.Arg_List("-where"))
+	if er != nil {
+		return c.Fail(er)
+	}
+	m, er := parse_mapping(c.Arg_List("-rename-type"), c.Arg_List("-rename-field"), c.Arg_List("-drop-field"), c.Arg_List("-convert"))
+	if er != nil {
+		return c.Fail(er)
+	}
+	plan, er := replay_plan(src, dst, filter, m)
+	if er != nil {
+		return c.Fail(er)
+	}
+
+	if c.Arg_Bool("-dry-run", false) {
+		render_plan(c, plan)
+		c.Message_Plural(len(plan.steps), "%d events would be replayed into '%s', %d skipped", len(plan.steps), dst, plan.Skipped)
+		return shell.OK
+	}
+	er = replay_apply(dst, plan)
+	if er != nil {
+		return c.Fail(er)
+	}
+	c.Message_Plural(len(plan.steps), "%d events replayed into '%s', %d skipped", len(plan.steps), dst, plan.Skipped)
+	return shell.OK
+}
+
+func complete_replay(tokens []string) []shell.Completion {
+	if len(tokens) > 2 {
+		return nil
+	}
+	r := []shell.Completion{}
+	for _, domain := range sorted_domains() {
+		r = append(r, shell.Completion{Value: domain})
+	}
+	return r
+}
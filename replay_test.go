@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"seva/lib/bone"
+	"strings"
+	"testing"
+)
+
+func Test_convert_value_ok(t *testing.T) {
+	convert := func(value string, from string, to string) string {
+		r, er := convert_value("T", "k", value, from, to)
+		bone.Assert(er == nil)
+		return r
+	}
+	bone.Assert(convert("2.0", "float", "int") == "2")
+	bone.Assert(convert("true", "bool", "int") == "1")
+	bone.Assert(convert("0", "bool", "int") == "0")
+	bone.Assert(convert("1", "int", "bool") == "true")
+	bone.Assert(convert("5", "int", "float") == "5")
+	bone.Assert(convert("5", "int", "string") == "5")
+
+	_, er := convert_value("T", "k", "2.5", "float", "int")
+	bone.Assert(er != nil)
+	_, er = convert_value("T", "k", "2", "int", "bool")
+	bone.Assert(er != nil)
+	_, er = convert_value("T", "k", "text", "string", "float")
+	bone.Assert(er != nil)
+}
+
+// Source domain with orders and payments, the target domain is removed.
+func replay_test_source() {
+	test_domain("replay_test")
+	if _, ok := signatures["replay_target"]; ok {
+		bone.Assert(delete_domain("replay_target") == nil)
+	}
+	_, er := add_signature("replay_test", "ORDER", map[string]string{"id": "int", "total": "float", "note": "string"})
+	bone.Assert(er == nil)
+	_, er = add_signature("replay_test", "PAYMENT", map[string]string{"id": "int", "amount": "float"})
+	bone.Assert(er == nil)
+	test_event("replay_test", "ORDER", map[string]string{"id": "1", "total": "10.0", "note": "a"})
+	test_event("replay_test", "PAYMENT", map[string]string{"id": "1", "amount": "10"})
+	test_event("replay_test", "ORDER", map[string]string{"id": "2", "total": "7", "note": "b"})
+}
+
+func replay_test_plan(where []string, rename_types []string, rename_fields []string, drops []string, converts []string) (*Replay_Plan, error) {
+	filter, er := parse_filter("", where)
+	bone.Assert(er == nil)
+	m, er := parse_mapping(rename_types, rename_fields, drops, converts)
+	bone.Assert(er == nil)
+	return replay_plan("replay_test", "replay_target", filter, m)
+}
+
+func Test_replay_plan_ok(t *testing.T) {
+	replay_test_source()
+	// Field mappings are keyed by source type names
+	plan, er := replay_test_plan([]string{"id=1"}, []string{"order=SALE"}, []string{"ORDER.total=sum"}, []string{"note"}, []string{"total=int", "PAYMENT.amount=int"})
+	bone.Assert(er == nil)
+	bone.Assert(plan.Skipped == 1 && len(plan.steps) == 2)
+	bone.Assert(strings.Join(plan.Created, ",") == "SALE,PAYMENT")
+	sale := plan.Signatures["SALE"]
+	bone.Assert(len(sale.Fields) == 2 && sale.Fields["sum"] == "int" && sale.Fields["id"] == "int")
+	bone.Assert(plan.steps[0].fields["sum"] == "10" && plan.steps[1].fields["amount"] == "10")
+
+	bone.Assert(replay_apply("replay_target", plan) == nil)
+	evs := events["replay_target"]
+	bone.Assert(len(evs) == 2 && evs[0].Seq == 1 && event_type_name("replay_target", evs[0]) == "SALE")
+	bone.Assert(chain_verify("replay_target").Ok)
+
+	// Replaying again reuses signatures of the target
+	plan, er = replay_test_plan([]string{"id=2"}, []string{"order=SALE"}, []string{"ORDER.total=sum"}, []string{"note"}, []string{"total=int"})
+	bone.Assert(er == nil && len(plan.Created) == 0 && len(plan.steps) == 1)
+}
+
+func Test_replay_plan_error(t *testing.T) {
+	replay_test_source()
+	var er error
+
+	// Fraction cannot become an int
+	_, er = replay_test_plan([]string{"id=2"}, nil, nil, nil, []string{"total=int"})
+	bone.Assert(er == nil)
+	test_event("replay_test", "ORDER", map[string]string{"id": "3", "total": "2.5", "note": "c"})
+	_, er = replay_test_plan(nil, nil, nil, nil, []string{"total=int"})
+	bone.Assert(errors.Is(er, ERR_REPLAY_EVENT))
+	_, ok := signatures["replay_target"]
+	bone.Assert(!ok)
+
+	// Types with different fields collide in the target type
+	_, er = replay_test_plan(nil, []string{"PAYMENT=ORDER"}, nil, nil, nil)
+	bone.Assert(er != nil)
+	// Two fields collide in the target field
+	_, er = replay_test_plan(nil, nil, []string{"ORDER.note=id"}, nil, nil)
+	bone.Assert(er != nil)
+
+	// Target type exists with other fields
+	bone.Assert(create_domain("replay_target") == nil)
+	_, er = add_signature("replay_target", "ORDER", map[string]string{"id": "int"})
+	bone.Assert(er == nil)
+	_, er = replay_test_plan(nil, nil, nil, nil, nil)
+	bone.Assert(er != nil)
+
+	filter, _ := parse_filter("", nil)
+	_, er = replay_plan("replay_test", "replay_test", filter, &Replay_Mapping{})
+	bone.Assert(er != nil)
+}
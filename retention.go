@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"sort"
+	"time"
+)
+
+// Retention is configured per domain in a section named `retention.DOMAIN`:
+//
+//	[retention.telemetry]
+//	max_days = 90
+//	max_count = 0
+//	action = archive
+//
+// Zero limits are disabled, so domains without the section keep everything.
+type Retention_Policy struct {
+	Max_Days  int
+	Max_Count int
+	// Either `delete` or `archive`.
+	Action string
+}
+
+func retention_policy(domain string) *Retention_Policy {
+	section := "retention." + domain
+	return &Retention_Policy{
+		Max_Days:  bone.Config.Int(section, "max_days"),
+		Max_Count: bone.Config.Int(section, "max_count"),
+		Action:    bone.Config.String(section, "action"),
+	}
+}
+
+// Returns amount of the oldest events of a domain which are expired by the
+// policy. Only a prefix of the history can expire, so sequences stay
+// continuous. Only the segment holding the age bound is read.
+func retention_expired(domain string, policy *Retention_Policy) (int, error) {
+	total := event_count(domain)
+	n := 0
+	if policy.Max_Count > 0 && total > policy.Max_Count {
+		n = total - policy.Max_Count
+	}
+	if policy.Max_Days <= 0 {
+		return n, nil
+	}
+
+	cutoff := bone.Utc() - int64(policy.Max_Days)*24*3600*1000
+	old := 0
+	index, ok := segment_indexes[domain]
+	for i := 0; ok && i < len(index.Segments); i++ {
+		segment := index.Segments[i]
+		if !segment.Sealed || segment.Last_Seq < segment.First_Seq {
+			continue
+		}
+		if segment.Last_Ms < cutoff {
+			old += segment.Last_Seq - segment.First_Seq + 1
+			continue
+		}
+		if segment.First_Ms < cutoff {
+			evs, er := segment_read(domain, segment)
+			if er != nil {
+				return 0, er
+			}
+			for _, event := range evs {
+				if event.Created_Ms < cutoff {
+					old++
+				}
+			}
+		}
+		return max(n, old), nil
+	}
+	for _, event := range events[domain] {
+		if event.Created_Ms >= cutoff {
+			break
+		}
+		old++
+	}
+	return max(n, old), nil
+}
+
+func retention_archive_path(domain string, first_seq int, last_seq int) string {
+	return bone.Userdir("archive", "retention", domain, fmt.Sprintf("%012d-%012d.json.gz", first_seq, last_seq))
+}
+
+func retention_archive(domain string, expired []*Event) error {
+	data, er := json.MarshalIndent(expired, "", "\t")
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("expired events of domain '%s'", domain)).Wrap(er)
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, er = writer.Write(data)
+	if er == nil {
+		er = writer.Close()
+	}
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("expired events of domain '%s'", domain)).Wrap(er)
+	}
+	path := retention_archive_path(domain, expired[0].Seq, expired[len(expired)-1].Seq)
+	bone.Mkdir(filepath.Dir(path))
+	return write_file_atomic(path, buffer.Bytes())
+}
+
+// Removes expired events of a domain from memory and segments, then
+// rebuilds projections and replaces snapshots, which still refer to the
+// removed history. Files of the removed segments are deleted last.
+func retention_apply(domain string) (int, error) {
+	policy := retention_policy(domain)
+	n, er := retention_expired(domain, policy)
+	if er != nil || n == 0 {
+		return 0, er
+	}
+	first_seq := next_seq(domain) - event_count(domain)
+	cutoff := first_seq + n - 1
+	expired, er := events_range(domain, first_seq, cutoff)
+	if er != nil {
+		return 0, er
+	}
+
+	switch policy.Action {
+	case "archive":
+		er := retention_archive(domain, expired)
+		if er != nil {
+			return 0, er
+		}
+	case "delete":
+	default:
+		return 0, ERR_RETENTION_ACTION.With("action", policy.Action, "domain", domain)
+	}
+
+	// Remaining events are written to new files and the new index first, so
+	// a failure leaves the domain as it was
+	index := segment_indexes[domain]
+	updated := &Segment_Index{
+		// The chain continues from the last removed event.
+		Base_Hash: expired[n-1].Hash,
+		Format:    index.Format,
+	}
+	obsolete := []string{}
+	written := []string{}
+	for _, segment := range index.Segments {
+		if segment.First_Seq > cutoff {
+			updated.Segments = append(updated.Segments, segment)
+			continue
+		}
+		obsolete = append(obsolete, segment_path(domain, segment))
+		if segment.Sealed && segment.Last_Seq <= cutoff {
+			continue
+		}
+		// Partially expired segment is re-written under it's new first
+		// sequence.
+		rest := &Segment{}
+		*rest = *segment
+		rest.First_Seq = cutoff + 1
+		if rest.Last_Seq < cutoff {
+			rest.Last_Seq = cutoff
+		}
+		// Active segment may be ahead of it's bounds in the index
+		to := segment.Last_Seq
+		if !segment.Sealed {
+			to = 0
+		}
+		rest_evs, er := events_range(domain, cutoff+1, to)
+		if er == nil {
+			if len(rest_evs) > 0 {
+				rest.First_Ms = rest_evs[0].Created_Ms
+			}
+			er = segment_write(domain, rest, rest_evs)
+		}
+		if er != nil {
+			retention_discard(written)
+			return 0, er
+		}
+		written = append(written, segment_path(domain, rest))
+		updated.Segments = append(updated.Segments, rest)
+	}
+	er = segment_store_index(domain, updated)
+	if er != nil {
+		retention_discard(written)
+		return 0, er
+	}
+
+	segment_indexes[domain] = updated
+	evs := events[domain]
+	i := sort.Search(len(evs), func(i int) bool {
+		return evs[i].Seq > cutoff
+	})
+	events[domain] = evs[i:]
+	project_reset(domain)
+	er = project_catch_up(domain)
+	if er != nil {
+		return 0, er
+	}
+	er = snapshot_prune(domain, 0)
+	if er != nil {
+		return 0, er
+	}
+	if event_count(domain) > 0 {
+		er = snapshot_take(domain)
+		if er != nil {
+			return 0, er
+		}
+	}
+	for _, path := range obsolete {
+		er := os.Remove(path)
+		if er != nil && !os.IsNotExist(er) {
+			return 0, ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+		}
+	}
+	return n, nil
+}
+
+// Removes segment files written by a failed retention run.
+func retention_discard(paths []string) {
+	for _, path := range paths {
+		er := os.Remove(path)
+		if er != nil && !os.IsNotExist(er) {
+			bone.Log_Error("Cannot remove segment file '%s', error: %s", path, er)
+		}
+	}
+}
+
+// Wakes the retention loop once `[retention]` or a domain policy changes.
+var retention_wake = make(chan struct{}, 1)
+
+// Applies retention to all domains periodically, until the process exits.
+func retention_loop() {
+	bone.Config.Subscribe("retention*", func() {
+		select {
+		case retention_wake <- struct{}{}:
+		default:
+		}
+	})
+	for {
+		interval := bone.Config.Int("retention", "interval_sec")
+		if interval <= 0 {
+			<-retention_wake
+			continue
+		}
+		state_lock.Lock()
+		for domain := range events {
+			n, er := retention_apply(domain)
+			if er != nil {
+				bone.Log_Error("%s", er)
+				continue
+			}
+			if n > 0 {
+				bone.Log_Info("Retention removed %d events of domain '%s'", n, domain)
+			}
+		}
+		state_lock.Unlock()
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+		case <-retention_wake:
+		}
+	}
+}
+
+func shell_prune(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	policy := retention_policy(domain)
+	if policy.Max_Days <= 0 && policy.Max_Count <= 0 {
+		c.Message("No retention configured for domain '%s'", domain)
+		return shell.OK
+	}
+
+	n, er := retention_expired(domain, policy)
+	if er != nil {
+		return c.Fail(er)
+	}
+	if c.Arg_Bool("-dry", false) {
+		c.Message_Plural(n, "%d events would be expired (%s)", n, policy.Action)
+		return shell.OK
+	}
+	text := fmt.Sprintf("Expire %d events of domain '%s' (%s)?", n, domain, policy.Action)
+	return c.Confirm(text, func() int {
+		n, er := retention_apply(domain)
+		if er != nil {
+			return c.Fail(er)
+		}
+		c.Message_Plural(n, "%d events expired (%s)", n, policy.Action)
+		return shell.OK
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"seva/lib/bone"
+	"testing"
+	"time"
+)
+
+// Adds an event a day for the amount of days, ending at the frozen current
+// time. Returns the previous clock to be restored.
+func retention_test_domain(days int) bone.Clock {
+	test_notes("retention_test")
+	clock, previous := bone.Freeze_Clock(time.Now().Add(-time.Duration(days) * 24 * time.Hour))
+	for i := 0; i < days; i++ {
+		clock.Advance(24 * time.Hour)
+		test_event("retention_test", "NOTE", map[string]string{"text": "x"})
+	}
+	return previous
+}
+
+func Test_retention_expired_ok(t *testing.T) {
+	defer bone.Set_Clock(retention_test_domain(10))
+	expired := func(policy *Retention_Policy) int {
+		n, er := retention_expired("retention_test", policy)
+		bone.Assert(er == nil)
+		return n
+	}
+	bone.Assert(expired(&Retention_Policy{}) == 0)
+	bone.Assert(expired(&Retention_Policy{Max_Count: 4}) == 6)
+	bone.Assert(expired(&Retention_Policy{Max_Count: 20}) == 0)
+	// Events of the last 3 days are kept, including the one exactly 3 days old
+	bone.Assert(expired(&Retention_Policy{Max_Days: 3}) == 6)
+	// The stricter limit wins
+	bone.Assert(expired(&Retention_Policy{Max_Days: 3, Max_Count: 2}) == 8)
+	bone.Assert(expired(&Retention_Policy{Max_Days: 3, Max_Count: 5}) == 6)
+}
+
+func Test_retention_apply_ok(t *testing.T) {
+	defer bone.Set_Clock(retention_test_domain(10))
+	t.Setenv("SEVA_RETENTION_RETENTION_TEST_MAX_COUNT", "4")
+	t.Setenv("SEVA_RETENTION_RETENTION_TEST_ACTION", "archive")
+	n, er := retention_apply("retention_test")
+	bone.Assert(er == nil && n == 6)
+
+	evs := events["retention_test"]
+	bone.Assert(len(evs) == 4 && evs[0].Seq == 7)
+	_, er = os.Stat(retention_archive_path("retention_test", 1, 6))
+	bone.Assert(er == nil)
+	// The rest of the chain still verifies from the removed events
+	bone.Assert(chain_verify("retention_test").Ok)
+	bone.Assert(next_seq("retention_test") == 11)
+}
+
+func Test_retention_apply_error(t *testing.T) {
+	defer bone.Set_Clock(retention_test_domain(10))
+	t.Setenv("SEVA_RETENTION_RETENTION_TEST_MAX_COUNT", "4")
+	t.Setenv("SEVA_RETENTION_RETENTION_TEST_ACTION", "delete")
+	index := segment_indexes["retention_test"]
+	// Remaining events cannot be written over a directory
+	blocked := segment_path("retention_test", &Segment{First_Seq: 7})
+	bone.Assert(os.Mkdir(blocked, 0755) == nil)
+	_, er := retention_apply("retention_test")
+	bone.Assert(errors.Is(er, ERR_STORAGE))
+
+	// Nothing is changed or left behind
+	files, er := os.ReadDir(segment_dir("retention_test"))
+	bone.Assert(er == nil && len(files) == 3)
+	bone.Assert(segment_indexes["retention_test"] == index && index.Base_Hash == "")
+	bone.Assert(len(index.Segments) == 1 && index.Segments[0].First_Seq == 1)
+	bone.Assert(event_count("retention_test") == 10)
+	bone.Assert(segment_read_domain("retention_test") == nil)
+	bone.Assert(len(events["retention_test"]) == 10)
+	bone.Assert(chain_verify("retention_test").Ok)
+
+	bone.Assert(os.Remove(blocked) == nil)
+	n, er := retention_apply("retention_test")
+	bone.Assert(er == nil && n == 6)
+	_, er = os.Stat(segment_path("retention_test", &Segment{First_Seq: 1}))
+	bone.Assert(os.IsNotExist(er))
+	bone.Assert(chain_verify("retention_test").Ok)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"seva/lib/bone"
+	"seva/lib/rpc"
+	"seva/lib/shell"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Inverted index over string fields of a domain's events.
+type Search_Index struct {
+	// Term frequencies of events by their tokens: {token: {seq: count}}
+	Postings map[string]map[int]int `json:"postings"`
+	// Amount of indexed events, used to weight rare tokens higher.
+	Size int `json:"size"`
+}
+
+type Search_Hit struct {
+	Score float64 `json:"score"`
+	Event *Event  `json:"event"`
+}
+
+// Search indexes by their domains
+var search_indexes = map[string]*Search_Index{}
+
+// Splits text to case folded tokens, everything except letters and digits
+// is a separator.
+func tokenize(text string) []string {
+	parts := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(parts))
+	for _, part := range parts {
+		tokens = append(tokens, strings.ToLower(part))
+	}
+	return tokens
+}
+
+var search_projection = &Projection{
+	Name: "search",
+	Reset: func(domain string) {
+		search_indexes[domain] = &Search_Index{
+			Postings: map[string]map[int]int{},
+		}
+	},
+	Drop: func(domain string) {
+		delete(search_indexes, domain)
+	},
+	Apply: search_index_event,
+	Save: func(domain string) any {
+		return search_indexes[domain]
+	},
+	Used: func(domain string) bool {
+		index, ok := search_indexes[domain]
+		return ok && index.Size > 0
+	},
+	Load: func(domain string, data json.RawMessage) error {
+		index := &Search_Index{}
+		er := json.Unmarshal(data, index)
+		if er != nil {
+			return ERR_DECODE.With("what", fmt.Sprintf("search index of domain '%s'", domain)).Wrap(er)
+		}
+		if index.Postings == nil {
+			index.Postings = map[string]map[int]int{}
+		}
+		search_indexes[domain] = index
+		return nil
+	},
+}
+
+// Only fields of type `string` are indexed, other values are not meant to be
+// searched as text.
+func search_index_event(domain string, event *Event, signature *Event_Signature) {
+	index, ok := search_indexes[domain]
+	if !ok {
+		index = &Search_Index{
+			Postings: map[string]map[int]int{},
+		}
+		search_indexes[domain] = index
+	}
+	if signature == nil {
+		return
+	}
+
+	indexed := false
+	for key, value := range event.Fields {
+		if signature.Fields[key] != "string" {
+			continue
+		}
+		for _, token := range tokenize(value) {
+			postings, ok := index.Postings[token]
+			if !ok {
+				postings = map[int]int{}
+				index.Postings[token] = postings
+			}
+			postings[event.Seq]++
+			indexed = true
+		}
+	}
+	if indexed {
+		index.Size++
+	}
+}
+
+// Returns events matching any of the terms, ranked by TF-IDF score. Events
+// matching more terms are ranked first. Only events of the returned hits are
+// read.
+func search(domain string, query string, limit int) ([]*Search_Hit, error) {
+	index, ok := search_indexes[domain]
+	if !ok {
+		return []*Search_Hit{}, nil
+	}
+
+	scores := map[int]float64{}
+	matched := map[int]int{}
+	for _, token := range tokenize(query) {
+		postings, ok := index.Postings[token]
+		if !ok {
+			continue
+		}
+		idf := math.Log(1 + float64(index.Size)/float64(len(postings)))
+		for seq, count := range postings {
+			scores[seq] += float64(count) * idf
+			matched[seq]++
+		}
+	}
+
+	seqs := []int{}
+	for seq := range scores {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		a := seqs[i]
+		b := seqs[j]
+		if matched[a] != matched[b] {
+			return matched[a] > matched[b]
+		}
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a > b
+	})
+	if limit > 0 && len(seqs) > limit {
+		seqs = seqs[:limit]
+	}
+
+	found, er := find_events(domain, seqs)
+	if er != nil {
+		return nil, er
+	}
+	hits := []*Search_Hit{}
+	for _, seq := range seqs {
+		event, ok := found[seq]
+		if ok {
+			hits = append(hits, &Search_Hit{Score: scores[seq], Event: event})
+		}
+	}
+	return hits, nil
+}
+
+func render_hits(c *shell.Command_Context, sigs []*Event_Signature, hits []*Search_Hit) {
+	if len(hits) == 0 {
+		c.Message("Nothing found")
+		return
+	}
+	rows := make([][]string, 0, len(hits))
+	evs := make([]*Event, 0, len(hits))
+	for _, hit := range hits {
+		rows = append(rows, []string{
+			strconv.Itoa(hit.Event.Seq),
+			fmt.Sprintf("%.3f", hit.Score),
+			bone.Date_Ms(hit.Event.Created_Ms, EVENT_DATE_FORMAT),
+			type_name_of(sigs, hit.Event),
+			format_fields(hit.Event.Fields),
+		})
+		evs = append(evs, hit.Event)
+	}
+	c.Table([]string{"SEQ", "SCORE", "TIME", "TYPE", "FIELDS"}, rows)
+	shell.Set_Hooks(evs)
+}
+
+func shell_search(c *shell.Command_Context) int {
+	query := c.Arg_String("_", "")
+	if query == "" {
+		c.Error("Specify search terms")
+		return shell.ERROR
+	}
+	domain := shell.Get_Domain()
+	hits, er := search(domain, query, c.Arg_Int("-n", 20))
+	if er != nil {
+		return c.Fail(er)
+	}
+	render_hits(c, signatures[domain], hits)
+	return shell.OK
+}
+
+type Rpc_Search_Args struct {
+	Domain string
+	Query  string
+	Limit  int
+}
+
+func rpc_search(c *gin.Context) {
+	var args Rpc_Search_Args
+	er := c.ShouldBindJSON(&args)
+	if er != nil {
+		rpc.Fail(c, ERR_INVALID_ARGUMENTS.With("reason", er))
+		return
+	}
+	if args.Limit <= 0 {
+		args.Limit = 20
+	}
+
+	state_lock.Lock()
+	defer state_lock.Unlock()
+	hits, er := search(args.Domain, args.Query, args.Limit)
+	if er != nil {
+		rpc.Fail(c, er)
+		return
+	}
+	rpc.Ok(c, hits)
+}
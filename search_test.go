@@ -0,0 +1,32 @@
+package main
+
+import (
+	"seva/lib/bone"
+	"slices"
+	"testing"
+)
+
+func Test_search_tokenize_ok(t *testing.T) {
+	bone.Assert(slices.Equal(tokenize("Hello, World! x-42"), []string{"hello", "world", "x", "42"}))
+	bone.Assert(slices.Equal(tokenize("Привет,МИР"), []string{"привет", "мир"}))
+	bone.Assert(len(tokenize(" ,.- ")) == 0)
+}
+
+func Test_search_ranking_ok(t *testing.T) {
+	evs := test_notes("search_test", "apple pie", "apple apple juice", "apple orange", "banana")
+	apple, apples, both := evs[0].Seq, evs[1].Seq, evs[2].Seq
+	_, er := add_signature("search_test", "COUNT", map[string]string{"n": "int"})
+	bone.Assert(er == nil)
+	test_event("search_test", "COUNT", map[string]string{"n": "1"})
+
+	hits := test_search("search_test", "apple orange", 0)
+	bone.Assert(len(hits) == 3)
+	// Matching more terms ranks first, then more occurrences of a term
+	bone.Assert(hits[0].Event.Seq == both)
+	bone.Assert(hits[1].Event.Seq == apples)
+	bone.Assert(hits[2].Event.Seq == apple)
+
+	bone.Assert(len(test_search("search_test", "APPLE", 1)) == 1)
+	bone.Assert(len(test_search("search_test", "1", 0)) == 0)
+	bone.Assert(len(test_search("no_such_domain", "apple", 0)) == 0)
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"sort"
+	"strconv"
+)
+
+// Part of a domain's event log holding a continuous range of sequences. Only
+// the last segment of a domain receives new events, others are sealed and
+// never change.
+type Segment struct {
+	First_Seq int `json:"first_seq"`
+	// Equals to `First_Seq - 1` for an empty segment.
+	Last_Seq int `json:"last_seq"`
+	// Creation time of the first event, used for time bounded rotation.
+	First_Ms int64 `json:"first_ms"`
+	Last_Ms  int64 `json:"last_ms"`
+	// Hash of the last event, so appending after a seal does not read the
+	// sealed segment.
+	Last_Hash string `json:"last_hash"`
+	Sealed    bool   `json:"sealed"`
+	// Archived segment is gzip compressed and moved to the archive directory.
+	Archived bool `json:"archived"`
+}
+
+// Segments of a domain ordered by their sequence ranges.
+type Segment_Index struct {
+	Segments []*Segment `json:"segments"`
+	// Hash of the last event removed by retention, the chain of stored events
+	// starts from it.
+	Base_Hash string `json:"base_hash"`
+	// Domains stored in an older format are read completely on startup and
+	// migrated, see `read_state`.
+	Format int `json:"format"`
+}
+
+// Since this format sealed segments are read only when needed, their bounds
+// are complete in the index.
+const segment_format = 1
+
+// Segment indexes by their domains
+var segment_indexes = map[string]*Segment_Index{}
+
+func segment_dir(domain string) string {
+	return bone.Userdir("events", domain)
+}
+
+func segment_archive_dir(domain string) string {
+	return bone.Userdir("archive", "events", domain)
+}
+
+func segment_path(domain string, segment *Segment) string {
+	if segment.Archived {
+		return filepath.Join(segment_archive_dir(domain), fmt.Sprintf("%012d.json.gz", segment.First_Seq))
+	}
+	return filepath.Join(segment_dir(domain), fmt.Sprintf("%012d.json", segment.First_Seq))
+}
+
+func segment_index_path(domain string) string {
+	return filepath.Join(segment_dir(domain), "index.json")
+}
+
+func segment_active(domain string) *Segment {
+	index, ok := segment_indexes[domain]
+	if !ok {
+		index = &Segment_Index{Format: segment_format}
+		segment_indexes[domain] = index
+	}
+	if len(index.Segments) == 0 || index.Segments[len(index.Segments)-1].Sealed {
+		first_seq := 1
+		if len(index.Segments) > 0 {
+			first_seq = index.Segments[len(index.Segments)-1].Last_Seq + 1
+		} else if len(events[domain]) > 0 {
+			first_seq = events[domain][0].Seq
+		}
+		index.Segments = append(index.Segments, &Segment{
+			First_Seq: first_seq,
+			Last_Seq:  first_seq - 1,
+		})
+	}
+	return index.Segments[len(index.Segments)-1]
+}
+
+// Returns in-memory events of a segment. Only events of the active segment
+// are kept in memory, except for domains being migrated.
+func segment_events(domain string, segment *Segment) []*Event {
+	evs := events[domain]
+	from := sort.Search(len(evs), func(i int) bool {
+		return evs[i].Seq >= segment.First_Seq
+	})
+	to := from
+	for to < len(evs) && (!segment.Sealed || evs[to].Seq <= segment.Last_Seq) {
+		to++
+	}
+	return evs[from:to]
+}
+
+func segment_encode(segment *Segment, evs []*Event) ([]byte, error) {
+	data, er := json.MarshalIndent(evs, "", "\t")
+	if er != nil {
+		return nil, er
+	}
+	if !segment.Archived {
+		return data, nil
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, er = writer.Write(data)
+	if er != nil {
+		return nil, er
+	}
+	er = writer.Close()
+	if er != nil {
+		return nil, er
+	}
+	return buffer.Bytes(), nil
+}
+
+func segment_write(domain string, segment *Segment, evs []*Event) error {
+	data, er := segment_encode(segment, evs)
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("segment #%d of domain '%s'", segment.First_Seq, domain)).Wrap(er)
+	}
+	path := segment_path(domain, segment)
+	bone.Mkdir(filepath.Dir(path))
+	return write_file_atomic(path, data)
+}
+
+func segment_read(domain string, segment *Segment) ([]*Event, error) {
+	path := segment_path(domain, segment)
+	f, er := os.Open(path)
+	// Active segment is listed in the index before it's first write.
+	if er != nil && !segment.Sealed && os.IsNotExist(er) {
+		return []*Event{}, nil
+	}
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "open", "path", path).Wrap(er)
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if segment.Archived {
+		gz, er := gzip.NewReader(f)
+		if er != nil {
+			return nil, ERR_CORRUPT.With("path", path).Wrap(er)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	data, er := io.ReadAll(reader)
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	evs := []*Event{}
+	er = json.Unmarshal(data, &evs)
+	if er != nil {
+		return nil, ERR_CORRUPT.With("path", path).Wrap(er)
+	}
+	return evs, nil
+}
+
+func segment_write_index(domain string) error {
+	return segment_store_index(domain, segment_indexes[domain])
+}
+
+// Writes an index, which is not in use yet.
+func segment_store_index(domain string, index *Segment_Index) error {
+	data, er := json.MarshalIndent(index, "", "\t")
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("segment index of domain '%s'", domain)).Wrap(er)
+	}
+	bone.Mkdir(segment_dir(domain))
+	return write_file_atomic(segment_index_path(domain), data)
+}
+
+func segment_read_index(domain string) error {
+	path := segment_index_path(domain)
+	data, er := os.ReadFile(path)
+	if er != nil {
+		return ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	index := &Segment_Index{}
+	er = json.Unmarshal(data, index)
+	if er != nil {
+		return ERR_CORRUPT.With("path", path).Wrap(er)
+	}
+	segment_indexes[domain] = index
+	return nil
+}
+
+// Reads index of a domain and events of it's active segment. Domains stored in
+// an older format are read completely.
+func segment_read_domain(domain string) error {
+	er := segment_read_index(domain)
+	if er != nil {
+		return er
+	}
+	index := segment_indexes[domain]
+	evs := []*Event{}
+	for _, segment := range index.Segments {
+		if segment.Sealed && index.Format >= segment_format {
+			continue
+		}
+		segment_evs, er := segment_read(domain, segment)
+		if er != nil {
+			return er
+		}
+		evs = append(evs, segment_evs...)
+	}
+	events[domain] = evs
+	return nil
+}
+
+// Reads events within the sequence range from disk, touching only segments
+// which overlap the range. Zero `to` means up to the end.
+func read_events_range(domain string, from int, to int) ([]*Event, error) {
+	index, ok := segment_indexes[domain]
+	if !ok {
+		return nil, ERR_DOMAIN_NOT_FOUND.With("domain", domain)
+	}
+	r := []*Event{}
+	for _, segment := range index.Segments {
+		if segment.Last_Seq < from || (to > 0 && segment.First_Seq > to) {
+			continue
+		}
+		evs, er := segment_read(domain, segment)
+		if er != nil {
+			return nil, er
+		}
+		for _, event := range evs {
+			if event.Seq >= from && (to <= 0 || event.Seq <= to) {
+				r = append(r, event)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Returns events within the sequence range, reading sealed segments which
+// overlap it from disk and the rest from memory. Zero `to` means up to the
+// end.
+func events_range(domain string, from int, to int) ([]*Event, error) {
+	r := []*Event{}
+	index, ok := segment_indexes[domain]
+	for i := 0; ok && i < len(index.Segments); i++ {
+		segment := index.Segments[i]
+		if !segment.Sealed || segment.Last_Seq < from || (to > 0 && segment.First_Seq > to) {
+			continue
+		}
+		evs, er := segment_read(domain, segment)
+		if er != nil {
+			return nil, er
+		}
+		for _, event := range evs {
+			if event.Seq >= from && (to <= 0 || event.Seq <= to) {
+				r = append(r, event)
+			}
+		}
+	}
+	for _, event := range events[domain] {
+		if event.Seq >= from && (to <= 0 || event.Seq <= to) {
+			r = append(r, event)
+		}
+	}
+	return r, nil
+}
+
+// Returns events by their sequences, reading each sealed segment holding any
+// of them once. Missing events are left out.
+func find_events(domain string, seqs []int) (map[int]*Event, error) {
+	wanted := map[int]bool{}
+	for _, seq := range seqs {
+		wanted[seq] = true
+	}
+	r := map[int]*Event{}
+	for _, event := range events[domain] {
+		if wanted[event.Seq] {
+			r[event.Seq] = event
+		}
+	}
+	index, ok := segment_indexes[domain]
+	for i := 0; ok && i < len(index.Segments); i++ {
+		segment := index.Segments[i]
+		needed := false
+		for _, seq := range seqs {
+			needed = needed || (seq >= segment.First_Seq && seq <= segment.Last_Seq)
+		}
+		if !segment.Sealed || !needed {
+			continue
+		}
+		evs, er := segment_read(domain, segment)
+		if er != nil {
+			return nil, er
+		}
+		for _, event := range evs {
+			if wanted[event.Seq] {
+				r[event.Seq] = event
+			}
+		}
+	}
+	return r, nil
+}
+
+// Passes events of a domain from the newest to the oldest to `visit`, until it
+// returns false. Sealed segments outside of the time range are not read, zero
+// bounds are open.
+func events_reverse(domain string, from_ms int64, to_ms int64, visit func(event *Event) bool) error {
+	evs := events[domain]
+	for i := len(evs) - 1; i >= 0; i-- {
+		if !visit(evs[i]) {
+			return nil
+		}
+	}
+	index, ok := segment_indexes[domain]
+	if !ok {
+		return nil
+	}
+	for i := len(index.Segments) - 1; i >= 0; i-- {
+		segment := index.Segments[i]
+		if !segment.Sealed || segment.Last_Seq < segment.First_Seq || (to_ms != 0 && segment.First_Ms >= to_ms) {
+			continue
+		}
+		// Older segments are older still
+		if from_ms != 0 && segment.Last_Ms < from_ms {
+			return nil
+		}
+		evs, er := segment_read(domain, segment)
+		if er != nil {
+			return er
+		}
+		for j := len(evs) - 1; j >= 0; j-- {
+			if !visit(evs[j]) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the last sealed segment holding events, or nil.
+func segment_last(domain string) *Segment {
+	index, ok := segment_indexes[domain]
+	if !ok {
+		return nil
+	}
+	for i := len(index.Segments) - 1; i >= 0; i-- {
+		segment := index.Segments[i]
+		if segment.Sealed && segment.Last_Seq >= segment.First_Seq {
+			return segment
+		}
+	}
+	return nil
+}
+
+// Returns amount of stored events of a domain, without reading sealed
+// segments.
+func event_count(domain string) int {
+	n := len(events[domain])
+	index, ok := segment_indexes[domain]
+	for i := 0; ok && i < len(index.Segments); i++ {
+		segment := index.Segments[i]
+		if segment.Sealed && segment.Last_Seq >= segment.First_Seq {
+			n += segment.Last_Seq - segment.First_Seq + 1
+		}
+	}
+	return n
+}
+
+// Converts the legacy single file domain log into segments. The index is
+// left in the older format, so the events are migrated by `read_state`.
+func segment_migrate(domain string, path string) error {
+	data, er := os.ReadFile(path)
+	if er != nil {
+		return ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	evs := []*Event{}
+	er = json.Unmarshal(data, &evs)
+	if er != nil {
+		return ERR_CORRUPT.With("path", path).Wrap(er)
+	}
+	for i, event := range evs {
+		if event.Seq == 0 {
+			event.Seq = i + 1
+		}
+	}
+	events[domain] = evs
+	segment_indexes[domain] = &Segment_Index{}
+	er = segment_rewrite(domain)
+	if er != nil {
+		return er
+	}
+	er = os.Remove(path)
+	if er != nil {
+		return ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+	}
+	return nil
+}
+
+// Writes all segments of a domain from memory, for the cases when the whole
+// history is read and changed, e.g. by a migration. Events of sealed segments
+// are dropped from memory afterwards.
+func segment_rewrite(domain string) error {
+	segment_active(domain)
+	for _, segment := range segment_indexes[domain].Segments {
+		evs := segment_events(domain, segment)
+		segment_bound(segment, evs)
+		er := segment_write(domain, segment, evs)
+		if er != nil {
+			return er
+		}
+	}
+	er := segment_write_index(domain)
+	if er != nil {
+		return er
+	}
+	segment_unload(domain)
+	return nil
+}
+
+// Drops events of sealed segments from memory, they are read from disk when
+// needed.
+func segment_unload(domain string) {
+	last_seq := 0
+	for _, segment := range segment_indexes[domain].Segments {
+		if segment.Sealed {
+			last_seq = segment.Last_Seq
+		}
+	}
+	evs := events[domain]
+	i := sort.Search(len(evs), func(i int) bool {
+		return evs[i].Seq > last_seq
+	})
+	events[domain] = append([]*Event{}, evs[i:]...)
+}
+
+// Sets bounds of a segment from it's events.
+func segment_bound(segment *Segment, evs []*Event) {
+	if len(evs) == 0 {
+		return
+	}
+	segment.Last_Seq = evs[len(evs)-1].Seq
+	segment.First_Ms = evs[0].Created_Ms
+	segment.Last_Ms = evs[len(evs)-1].Created_Ms
+	segment.Last_Hash = evs[len(evs)-1].Hash
+}
+
+func segment_exceeds(segment *Segment, size int) bool {
+	max_bytes := bone.Config.Int("segment", "max_bytes")
+	if max_bytes > 0 && size >= max_bytes {
+		return true
+	}
+	max_hours := bone.Config.Int("segment", "max_hours")
+	if max_hours > 0 && segment.Last_Seq >= segment.First_Seq &&
+		bone.Utc()-segment.First_Ms >= int64(max_hours)*3600*1000 {
+		return true
+	}
+	return false
+}
+
+// Writes the active segment of a domain, rotating it if it's bounds are
+// exceeded.
+func segment_save(domain string) error {
+	segment := segment_active(domain)
+	evs := segment_events(domain, segment)
+	segment_bound(segment, evs)
+	data, er := segment_encode(segment, evs)
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("segment #%d of domain '%s'", segment.First_Seq, domain)).Wrap(er)
+	}
+
+	if segment_exceeds(segment, len(data)) {
+		return segment_seal(domain, segment, evs)
+	}
+
+	bone.Mkdir(segment_dir(domain))
+	er = write_file_atomic(segment_path(domain, segment), data)
+	if er != nil {
+		return er
+	}
+	return segment_write_index(domain)
+}
+
+func segment_seal(domain string, segment *Segment, evs []*Event) error {
+	segment.Sealed = true
+	var er error
+	if bone.Config.Bool("segment", "compress") {
+		er = segment_archive(domain, segment, evs)
+	} else {
+		er = segment_write(domain, segment, evs)
+		if er == nil {
+			er = segment_write_index(domain)
+		}
+	}
+	if er != nil {
+		return er
+	}
+	segment_unload(domain)
+	return nil
+}
+
+// Compresses a sealed segment and moves it to the archive directory.
+func segment_archive(domain string, segment *Segment, evs []*Event) error {
+	if !segment.Sealed || segment.Archived {
+		return nil
+	}
+	plain_path := segment_path(domain, segment)
+	segment.Archived = true
+	er := segment_write(domain, segment, evs)
+	if er != nil {
+		segment.Archived = false
+		return er
+	}
+	er = segment_write_index(domain)
+	if er != nil {
+		return er
+	}
+	er = os.Remove(plain_path)
+	if er != nil && !os.IsNotExist(er) {
+		return ERR_STORAGE.With("action", "remove", "path", plain_path).Wrap(er)
+	}
+	return nil
+}
+
+// Merges adjacent sealed segments, e.g. produced by time bounded rotation of
+// a quiet domain, as long as the result fits into the size bound.
+func segment_compact(domain string) error {
+	index, ok := segment_indexes[domain]
+	if !ok {
+		return nil
+	}
+	max_bytes := bone.Config.Int("segment", "max_bytes")
+
+	compacted := []*Segment{}
+	obsolete := []string{}
+	var current *Segment = nil
+	current_size := 0
+	for _, segment := range index.Segments {
+		if !segment.Sealed {
+			compacted = append(compacted, segment)
+			continue
+		}
+		evs, er := segment_read(domain, segment)
+		if er != nil {
+			return er
+		}
+		data, er := segment_encode(segment, evs)
+		if er != nil {
+			return ERR_ENCODE.With("what", fmt.Sprintf("segment #%d of domain '%s'", segment.First_Seq, domain)).Wrap(er)
+		}
+		if current != nil && current.Archived == segment.Archived &&
+			(max_bytes <= 0 || current_size+len(data) <= max_bytes) {
+			obsolete = append(obsolete, segment_path(domain, segment))
+			current.Last_Seq = segment.Last_Seq
+			current.Last_Ms = segment.Last_Ms
+			current.Last_Hash = segment.Last_Hash
+			current_size += len(data)
+			continue
+		}
+		current = &Segment{}
+		*current = *segment
+		current_size = len(data)
+		compacted = append(compacted, current)
+	}
+
+	// Merged segments are read through the old index, their files are
+	// removed only after the new one is written
+	for _, segment := range compacted {
+		if !segment.Sealed {
+			continue
+		}
+		evs, er := read_events_range(domain, segment.First_Seq, segment.Last_Seq)
+		if er != nil {
+			return er
+		}
+		er = segment_write(domain, segment, evs)
+		if er != nil {
+			return er
+		}
+	}
+	index.Segments = compacted
+	er := segment_write_index(domain)
+	if er != nil {
+		return er
+	}
+	for _, path := range obsolete {
+		er := os.Remove(path)
+		if er != nil {
+			return ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+		}
+	}
+	return nil
+}
+
+func shell_segments(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	action := c.Arg_String("_", "list")
+
+	switch action {
+	case "list":
+		index, ok := segment_indexes[domain]
+		if !ok {
+			c.Message("No segments")
+			return shell.OK
+		}
+		rows := [][]string{}
+		for _, segment := range index.Segments {
+			state := "active"
+			if segment.Archived {
+				state = "archived"
+			} else if segment.Sealed {
+				state = "sealed"
+			}
+			last := strconv.Itoa(segment.Last_Seq)
+			if segment.Last_Seq < segment.First_Seq {
+				last = ""
+			}
+			rows = append(rows, []string{strconv.Itoa(segment.First_Seq), last, state})
+		}
+		c.Table([]string{"FIRST", "LAST", "STATE"}, rows)
+	case "seal":
+		segment := segment_active(domain)
+		evs := segment_events(domain, segment)
+		if len(evs) == 0 {
+			return c.Fail(ERR_SEGMENT_EMPTY.With("domain", domain))
+		}
+		er := segment_seal(domain, segment, evs)
+		if er != nil {
+			return c.Fail(er)
+		}
+	case "archive":
+		segment_active(domain)
+		for _, segment := range segment_indexes[domain].Segments {
+			if !segment.Sealed || segment.Archived {
+				continue
+			}
+			evs, er := segment_read(domain, segment)
+			if er == nil {
+				er = segment_archive(domain, segment, evs)
+			}
+			if er != nil {
+				return c.Fail(er)
+			}
+		}
+	case "compact":
+		er := segment_compact(domain)
+		if er != nil {
+			return c.Fail(er)
+		}
+	default:
+		c.Error("Unrecognized segments action '%s', expected one of: list, seal, archive, compact", action)
+		return shell.ERROR
+	}
+	return shell.OK
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"testing"
+)
+
+func segment_test_notes(n int) {
+	texts := []string{}
+	for i := 0; i < n; i++ {
+		texts = append(texts, "some text")
+	}
+	test_notes("segment_test", texts...)
+}
+
+func Test_segment_seal_ok(t *testing.T) {
+	t.Setenv("SEVA_SEGMENT_MAX_BYTES", "400")
+	t.Setenv("SEVA_SEGMENT_COMPRESS", "true")
+	segment_test_notes(10)
+
+	segments := segment_indexes["segment_test"].Segments
+	bone.Assert(len(segments) > 2)
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		bone.Assert(segment.Sealed != last && segment.Archived != last)
+		if i > 0 {
+			bone.Assert(segment.First_Seq == segments[i-1].Last_Seq+1)
+		}
+	}
+
+	evs, er := read_events_range("segment_test", 2, 9)
+	bone.Assert(er == nil && len(evs) == 8 && evs[0].Seq == 2 && evs[7].Seq == 9)
+	// Only the active segment is kept in memory
+	bone.Assert(segment_read_domain("segment_test") == nil)
+	active := segments[len(segments)-1]
+	bone.Assert(len(events["segment_test"]) == active.Last_Seq-active.First_Seq+1)
+	bone.Assert(event_count("segment_test") == 10)
+	evs, er = events_range("segment_test", 1, 0)
+	bone.Assert(er == nil && len(evs) == 10 && evs[9].Seq == 10)
+}
+
+func Test_segment_read_lazy_ok(t *testing.T) {
+	t.Setenv("SEVA_SEGMENT_MAX_BYTES", "400")
+	test_notes("segment_test", "a", "b", "c", "d", "e", "f", "g", "h")
+	bone.Assert(segment_read_domain("segment_test") == nil)
+	segments := segment_indexes["segment_test"].Segments
+	bone.Assert(len(segments) > 2 && segments[0].Sealed && segments[1].Sealed)
+	// Segments are opened only when needed, so a lost old one is noticed
+	// only when it's events are read
+	bone.Assert(os.Remove(segment_path("segment_test", segments[0])) == nil)
+
+	evs, er := select_events("segment_test", 2, "", "")
+	bone.Assert(er == nil && len(evs) == 2 && evs[1].Seq == 8)
+	event, er := find_event("segment_test", segments[1].First_Seq)
+	bone.Assert(er == nil && event.Seq == segments[1].First_Seq)
+	found, er := find_events("segment_test", []int{segments[1].Last_Seq, 8, 100})
+	bone.Assert(er == nil && len(found) == 2)
+	_, er = find_event("segment_test", 1)
+	bone.Assert(errors.Is(er, ERR_STORAGE))
+	_, er = select_events("segment_test", 0, "", "")
+	bone.Assert(errors.Is(er, ERR_STORAGE))
+
+	// Appending continues the chain from the sealed segment
+	test_event("segment_test", "NOTE", map[string]string{"text": "i"})
+	bone.Assert(segment_seal("segment_test", segment_active("segment_test"), events["segment_test"]) == nil)
+	bone.Assert(len(events["segment_test"]) == 0)
+	event = test_event("segment_test", "NOTE", map[string]string{"text": "j"})
+	previous, er := find_event("segment_test", event.Seq-1)
+	bone.Assert(er == nil && event.Hash == chain_hash(previous.Hash, event))
+}
+
+func Test_segment_compact_ok(t *testing.T) {
+	t.Setenv("SEVA_SEGMENT_MAX_BYTES", "400")
+	segment_test_notes(10)
+	before := len(segment_indexes["segment_test"].Segments)
+	bone.Assert(before > 2)
+
+	t.Setenv("SEVA_SEGMENT_MAX_BYTES", "0")
+	bone.Assert(segment_compact("segment_test") == nil)
+	segments := segment_indexes["segment_test"].Segments
+	bone.Assert(len(segments) == 2)
+	bone.Assert(segments[0].Sealed && segments[0].First_Seq == 1 && segments[0].Last_Seq == segments[1].First_Seq-1)
+
+	files, er := os.ReadDir(segment_dir("segment_test"))
+	bone.Assert(er == nil)
+	// Index and the files of both segments
+	bone.Assert(len(files) == 3)
+	bone.Assert(segment_read_domain("segment_test") == nil)
+	evs, er := events_range("segment_test", 1, 0)
+	bone.Assert(er == nil && len(evs) == 10)
+	bone.Assert(chain_verify("segment_test").Ok)
+}
+
+func Test_segment_migrate_ok(t *testing.T) {
+	test_notes("segment_test")
+	legacy := []*Event{
+		{Created_Sec: 100, Type: 1, Fields: map[string]string{"text": "a"}},
+		{Created_Sec: 200, Type: 1, Fields: map[string]string{"text": "b"}},
+	}
+	data, er := json.Marshal(legacy)
+	bone.Assert(er == nil)
+	path := filepath.Join(bone.Userdir("events"), "segment_test.json")
+	bone.Assert(os.WriteFile(path, data, 0644) == nil)
+
+	bone.Assert(segment_migrate("segment_test", path) == nil)
+	_, er = os.Stat(path)
+	bone.Assert(os.IsNotExist(er))
+	bone.Assert(segment_read_domain("segment_test") == nil)
+	evs := events["segment_test"]
+	bone.Assert(len(evs) == 2 && evs[0].Seq == 1 && evs[1].Seq == 2 && evs[1].Fields["text"] == "b")
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// State of all projections of a domain at some point of it's history.
+type Snapshot struct {
+	// Sequence of the last event applied to the projections.
+	Seq         int `json:"seq"`
+	Created_Sec int `json:"created_sec"`
+	// Hex SHA-256 of the projections data.
+	Checksum    string                     `json:"checksum"`
+	Projections map[string]json.RawMessage `json:"projections"`
+}
+
+func snapshot_dir(domain string) string {
+	return bone.Userdir("snapshots", domain)
+}
+
+func snapshot_checksum(projections map[string]json.RawMessage) (string, error) {
+	// Raw messages are compacted on marshalling and map keys are sorted, so
+	// the same data always produce the same checksum.
+	data, er := json.Marshal(projections)
+	if er != nil {
+		return "", er
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Returns sequences of stored snapshots of a domain, from the oldest to the
+// newest.
+func snapshot_list(domain string) []int {
+	files, er := os.ReadDir(snapshot_dir(domain))
+	if er != nil {
+		return []int{}
+	}
+	seqs := []int{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		name, _ := strings.CutSuffix(file.Name(), ".json")
+		seq, er := strconv.Atoi(name)
+		if er != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+func snapshot_path(domain string, seq int) string {
+	return filepath.Join(snapshot_dir(domain), fmt.Sprintf("%012d.json", seq))
+}
+
+// Stores current state of projections of a domain.
+func snapshot_take(domain string) error {
+	projections_data := map[string]json.RawMessage{}
+	for _, p := range projections {
+		data, er := json.Marshal(p.Save(domain))
+		if er != nil {
+			return ERR_ENCODE.With("what", fmt.Sprintf("projection '%s' of domain '%s'", p.Name, domain)).Wrap(er)
+		}
+		projections_data[p.Name] = data
+	}
+	checksum, er := snapshot_checksum(projections_data)
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("snapshot checksum of domain '%s'", domain)).Wrap(er)
+	}
+	snapshot := &Snapshot{
+		Seq:         projected_seq[domain],
+		Created_Sec: int(bone.Utc_Sec()),
+		Checksum:    checksum,
+		Projections: projections_data,
+	}
+	data, er := json.Marshal(snapshot)
+	if er != nil {
+		return ERR_ENCODE.With("what", fmt.Sprintf("snapshot of domain '%s'", domain)).Wrap(er)
+	}
+
+	bone.Mkdir(snapshot_dir(domain))
+	return write_file_atomic(snapshot_path(domain, snapshot.Seq), data)
+}
+
+func snapshot_read(domain string, seq int) (*Snapshot, error) {
+	path := snapshot_path(domain, seq)
+	data, er := os.ReadFile(path)
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "read", "path", path).Wrap(er)
+	}
+	snapshot := &Snapshot{}
+	er = json.Unmarshal(data, snapshot)
+	if er != nil {
+		return nil, ERR_CORRUPT.With("path", path).Wrap(er)
+	}
+	checksum, er := snapshot_checksum(snapshot.Projections)
+	if er != nil || checksum != snapshot.Checksum {
+		return nil, ERR_CORRUPT.With("path", path)
+	}
+	return snapshot, nil
+}
+
+func snapshot_load(domain string, snapshot *Snapshot) error {
+	for _, p := range projections {
+		data, ok := snapshot.Projections[p.Name]
+		if !ok {
+			return ERR_SNAPSHOT_INCOMPLETE.With("seq", snapshot.Seq, "domain", domain, "projection", p.Name)
+		}
+		er := p.Load(domain, data)
+		if er != nil {
+			return er
+		}
+	}
+	projected_seq[domain] = snapshot.Seq
+	return nil
+}
+
+// Loads the newest valid snapshot of a domain, which does not exceed it's
+// history. If there is none, projections are left untouched.
+func snapshot_restore(domain string) {
+	last_seq := next_seq(domain) - 1
+
+	seqs := snapshot_list(domain)
+	for i := len(seqs) - 1; i >= 0; i-- {
+		if seqs[i] > last_seq {
+			continue
+		}
+		snapshot, er := snapshot_read(domain, seqs[i])
+		if er == nil {
+			er = snapshot_load(domain, snapshot)
+		}
+		if er != nil {
+			bone.Log_Error("%s", er)
+			project_reset(domain)
+			continue
+		}
+		return
+	}
+}
+
+// Takes a snapshot each time the configured amount of events is appended.
+func snapshot_periodic(domain string) {
+	interval := bone.Config.Int("snapshot", "interval")
+	if interval <= 0 {
+		return
+	}
+	seq := projected_seq[domain]
+	if seq == 0 || seq%interval != 0 {
+		return
+	}
+	er := snapshot_take(domain)
+	if er == nil {
+		er = snapshot_prune(domain, bone.Config.Int("snapshot", "keep"))
+	}
+	if er != nil {
+		bone.Log_Error("%s", er)
+	}
+}
+
+// Checks that a snapshot is intact and equals to the projections replayed
+// from the history up to it's sequence.
+func snapshot_verify(domain string, seq int) error {
+	snapshot, er := snapshot_read(domain, seq)
+	if er != nil {
+		return er
+	}
+
+	// Replay into a scratch domain name, so the live projections are kept.
+	scratch := domain + "/verify"
+	defer project_drop(scratch)
+	project_reset(scratch)
+	// History is read from disk, so the snapshot is checked against what is
+	// actually stored.
+	evs, er := read_events_range(domain, 1, seq)
+	if er != nil {
+		return er
+	}
+	for _, event := range evs {
+		project_apply(scratch, event, find_event_signature(domain, event))
+	}
+
+	for _, p := range projections {
+		data, er := json.Marshal(p.Save(scratch))
+		if er != nil {
+			return ERR_ENCODE.With("what", fmt.Sprintf("projection '%s' of domain '%s'", p.Name, domain)).Wrap(er)
+		}
+		expected, _ := snapshot_checksum(map[string]json.RawMessage{p.Name: data})
+		actual, _ := snapshot_checksum(map[string]json.RawMessage{p.Name: snapshot.Projections[p.Name]})
+		if expected != actual {
+			return ERR_SNAPSHOT_DIFFERS.With("projection", p.Name, "seq", seq)
+		}
+	}
+	return nil
+}
+
+// Removes all snapshots of a domain except `keep` newest ones.
+func snapshot_prune(domain string, keep int) error {
+	seqs := snapshot_list(domain)
+	if keep < 0 {
+		keep = 0
+	}
+	for i := 0; i < len(seqs)-keep; i++ {
+		path := snapshot_path(domain, seqs[i])
+		er := os.Remove(path)
+		if er != nil {
+			return ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+		}
+	}
+	return nil
+}
+
+func shell_snapshot(c *shell.Command_Context) int {
+	domain := shell.Get_Domain()
+	action := c.Arg_String("_", "take")
+
+	switch action {
+	case "take":
+		er := snapshot_take(domain)
+		if er != nil {
+			return c.Fail(er)
+		}
+		c.Message("Snapshot #%d taken", projected_seq[domain])
+	case "list":
+		seqs := snapshot_list(domain)
+		if len(seqs) == 0 {
+			c.Message("No snapshots")
+			return shell.OK
+		}
+		rows := [][]string{}
+		for _, seq := range seqs {
+			snapshot, er := snapshot_read(domain, seq)
+			if er != nil {
+				rows = append(rows, []string{strconv.Itoa(seq), "", "broken"})
+				continue
+			}
+			rows = append(rows, []string{strconv.Itoa(seq), bone.Date_Sec(snapshot.Created_Sec, DATE_FORMAT), "ok"})
+		}
+		c.Table([]string{"SEQ", "TIME", "STATE"}, rows)
+	case "verify":
+		failed := false
+		for _, seq := range snapshot_list(domain) {
+			er := snapshot_verify(domain, seq)
+			if er != nil {
+				c.Fail(er)
+				failed = true
+				continue
+			}
+			c.Message("#%d OK", seq)
+		}
+		if failed {
+			return shell.ERROR
+		}
+	case "prune":
+		er := snapshot_prune(domain, c.Arg_Int("-keep", bone.Config.Int("snapshot", "keep")))
+		if er != nil {
+			return c.Fail(er)
+		}
+	default:
+		c.Error("Unrecognized snapshot action '%s', expected one of: take, list, verify, prune", action)
+		return shell.ERROR
+	}
+	return shell.OK
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"seva/lib/bone"
+	"testing"
+)
+
+func Test_snapshot_verify_ok(t *testing.T) {
+	second := test_notes("snapshot_test", "first", "second")[1]
+
+	bone.Assert(snapshot_take("snapshot_test") == nil)
+	bone.Assert(snapshot_verify("snapshot_test", second.Seq) == nil)
+
+	// Restored state equals the projected one
+	search_projection.Reset("snapshot_test")
+	snapshot_restore("snapshot_test")
+	bone.Assert(len(test_search("snapshot_test", "second", 0)) == 1)
+}
+
+func Test_snapshot_verify_error(t *testing.T) {
+	event := test_notes("snapshot_test", "first")[0]
+	bone.Assert(snapshot_take("snapshot_test") == nil)
+	path := snapshot_path("snapshot_test", event.Seq)
+	data, er := os.ReadFile(path)
+	bone.Assert(er == nil)
+	snapshot := &Snapshot{}
+	bone.Assert(json.Unmarshal(data, snapshot) == nil)
+
+	// Data changed without the checksum
+	snapshot.Projections["search"] = json.RawMessage(`{"postings":{},"size":0}`)
+	data, _ = json.Marshal(snapshot)
+	bone.Assert(os.WriteFile(path, data, 0644) == nil)
+	_, er = snapshot_read("snapshot_test", event.Seq)
+	bone.Assert(errors.Is(er, ERR_CORRUPT))
+	bone.Assert(errors.Is(snapshot_verify("snapshot_test", event.Seq), ERR_CORRUPT))
+
+	// Intact snapshot, which differs from the history
+	snapshot.Checksum, _ = snapshot_checksum(snapshot.Projections)
+	data, _ = json.Marshal(snapshot)
+	bone.Assert(os.WriteFile(path, data, 0644) == nil)
+	_, er = snapshot_read("snapshot_test", event.Seq)
+	bone.Assert(er == nil)
+	bone.Assert(errors.Is(snapshot_verify("snapshot_test", event.Seq), ERR_SNAPSHOT_DIFFERS))
+}
+
+func Test_snapshot_restore_newer_ok(t *testing.T) {
+	t.Setenv("SEVA_SEGMENT_MAX_BYTES", "400")
+	test_notes("snapshot_test", "apple", "pear", "plum", "cherry")
+	bone.Assert(snapshot_take("snapshot_test") == nil)
+	test_event("snapshot_test", "NOTE", map[string]string{"text": "apple juice"})
+
+	// Segments covered by the snapshot are not read, so a lost one goes
+	// unnoticed
+	segment := segment_indexes["snapshot_test"].Segments[0]
+	bone.Assert(segment.Sealed && segment.Last_Seq <= 4)
+	bone.Assert(os.Remove(segment_path("snapshot_test", segment)) == nil)
+	bone.Assert(project_restore("snapshot_test") == nil)
+	bone.Assert(projected_seq["snapshot_test"] == 5)
+	bone.Assert(search_indexes["snapshot_test"].Size == 5)
+	bone.Assert(len(search_indexes["snapshot_test"].Postings["apple"]) == 2)
+
+	// Without the snapshot the whole history is read
+	bone.Assert(snapshot_prune("snapshot_test", 0) == nil)
+	bone.Assert(errors.Is(project_restore("snapshot_test"), ERR_STORAGE))
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operations on the state shared by the shell and the server. Errors are
+// returned instead of logged, so each caller reports them it's own way.
+
+var field_types = []string{"int", "string", "float", "bool", "array", "dict"}
+
+func create_domain(domain string) error {
+	if !shell.Is_Valid_Domain(domain) || domain == "" {
+		return ERR_DOMAIN_INVALID.With("domain", domain)
+	}
+	_, ok := signatures[domain]
+	if ok {
+		return ERR_DOMAIN_EXISTS.With("domain", domain)
+	}
+	events[domain] = []*Event{}
+	signatures[domain] = []*Event_Signature{}
+	save_state()
+	return nil
+}
+
+func add_signature(domain string, type_name string, fields map[string]string) (*Event_Signature, error) {
+	type_name = strings.ToUpper(type_name)
+	if type_name == "" {
+		return nil, ERR_TYPE_MISSING
+	}
+	_, ok := signatures[domain]
+	if !ok {
+		return nil, ERR_DOMAIN_NOT_FOUND.With("domain", domain)
+	}
+	_, existing := find_signature(domain, type_name)
+	if existing != nil {
+		return nil, ERR_SIGNATURE_EXISTS.With("type", type_name)
+	}
+
+	types := map[string]string{}
+	defaults := map[string]string{}
+	for key, value := range fields {
+		// Type may be followed by a default value, e.g. `int:1`
+		field_type, default_, has_default := strings.Cut(value, ":")
+
+		// We store string anyways, but check signature
+		known := false
+		for _, t := range field_types {
+			known = known || field_type == t
+		}
+		if !known {
+			return nil, ERR_FIELD_TYPE_UNKNOWN.With("type", type_name, "field", key, "value", field_type)
+		}
+		if has_default {
+			er := validate_field(type_name, key, field_type, default_)
+			if er != nil {
+				return nil, er
+			}
+			defaults[key] = default_
+		}
+		types[key] = field_type
+	}
+
+	signature := &Event_Signature{
+		Type_Name: type_name,
+		Fields:    types,
+	}
+	if len(defaults) > 0 {
+		signature.Defaults = defaults
+	}
+	signatures[domain] = append(signatures[domain], signature)
+
+	save_state()
+	return signature, nil
+}
+
+func validate_field(type_name string, key string, sig_value string, value string) error {
+	// We store string anyways, but check signature
+	switch sig_value {
+	case "int":
+		_, er := strconv.Atoi(value)
+		if er != nil {
+			return ERR_FIELD_VALUE.With("type", type_name, "field", key, "value", value, "field_type", sig_value)
+		}
+	case "string":
+	case "float":
+		_, er := strconv.ParseFloat(value, 64)
+		if er != nil {
+			return ERR_FIELD_VALUE.With("type", type_name, "field", key, "value", value, "field_type", sig_value)
+		}
+	case "bool":
+		if value != "1" && value != "0" && value != "true" && value != "false" {
+			return ERR_FIELD_VALUE.With("type", type_name, "field", key, "value", value, "field_type", sig_value)
+		}
+	// @Todo implement parsers for arr and dict
+	case "array":
+	case "dict":
+	default:
+		return ERR_FIELD_TYPE_UNKNOWN.With("type", type_name, "field", key, "value", sig_value)
+	}
+	return nil
+}
+
+func add_event(domain string, type_name string, fields map[string]string) (*Event, error) {
+	type_name = strings.ToUpper(type_name)
+	_, ok := signatures[domain]
+	if !ok {
+		return nil, ERR_DOMAIN_NOT_FOUND.With("domain", domain)
+	}
+	target_signature_type, target_signature := find_signature(domain, type_name)
+	if target_signature == nil {
+		return nil, ERR_SIGNATURE_NOT_FOUND.With("type", type_name)
+	}
+	if target_signature.Deprecated {
+		return nil, ERR_SIGNATURE_DEPRECATED.With("type", type_name)
+	}
+
+	// Compare fields with signature
+	for key, value := range fields {
+		sig_value, ok := target_signature.Fields[key]
+		if !ok {
+			return nil, ERR_FIELD_UNKNOWN.With("type", type_name, "field", key)
+		}
+		er := validate_field(type_name, key, sig_value, value)
+		if er != nil {
+			return nil, er
+		}
+	}
+
+	event := append_event(domain, target_signature_type, fields, bone.Utc())
+
+	save_state()
+	snapshot_periodic(domain)
+	chain_checkpoint_periodic(domain)
+	return event, nil
+}
+
+// Chains an already validated event to the history of a domain, without
+// saving it.
+//
+// Time is moved past the last event of the domain if needed, so times of a
+// domain strictly increase even if the clock goes back.
+func append_event(domain string, type_ int, fields map[string]string, created_ms int64) *Event {
+	var last_ms int64
+	evs := events[domain]
+	if len(evs) > 0 {
+		last_ms = evs[len(evs)-1].Created_Ms
+	} else if segment := segment_last(domain); segment != nil {
+		last_ms = segment.Last_Ms
+	}
+	if created_ms <= last_ms {
+		created_ms = last_ms + 1
+	}
+	event := &Event{
+		Seq:        next_seq(domain),
+		Created_Ms: created_ms,
+		Type:       type_,
+		Fields:     fields,
+	}
+	chain_link(domain, event)
+	events[domain] = append(events[domain], event)
+	project_event(domain, event)
+	notify(domain, signatures[domain][type_-1].Type_Name, event)
+	return event
+}
+
+// Converts times of events stored before milliseconds were introduced. Events
+// of the same second are spread by a millisecond, keeping times increasing.
+// Returns whether anything changed, segment bounds are then set by
+// `segment_rewrite`.
+func migrate_event_times(domain string) bool {
+	changed := false
+	var last int64
+	for _, event := range events[domain] {
+		if event.Created_Ms == 0 {
+			event.Created_Ms = max(int64(event.Created_Sec)*1000, last+1)
+			changed = true
+		}
+		last = event.Created_Ms
+	}
+	return changed
+}
+
+// Returns up to `n` newest events, optionally of a single type, in the order
+// of their history. Non-positive `n` means no limit. Non-empty `period` is a
+// time range, see `parse_time_range`.
+func select_events(domain string, n int, type_name string, period string) ([]*Event, error) {
+	target_type := 0
+	if type_name != "" {
+		target_type, _ = find_signature(domain, type_name)
+		if target_type == 0 {
+			return nil, ERR_SIGNATURE_NOT_FOUND.With("type", type_name)
+		}
+	}
+	from_ms, to_ms, er := parse_time_range(period)
+	if er != nil {
+		return nil, er
+	}
+
+	selected := []*Event{}
+	er = events_reverse(domain, from_ms, to_ms, func(event *Event) bool {
+		if from_ms != 0 && event.Created_Ms < from_ms {
+			return false
+		}
+		if (target_type == 0 || event.Type == target_type) && (to_ms == 0 || event.Created_Ms < to_ms) {
+			selected = append(selected, event)
+		}
+		return n <= 0 || len(selected) < n
+	})
+	if er != nil {
+		return nil, er
+	}
+	for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+		selected[i], selected[j] = selected[j], selected[i]
+	}
+	return selected, nil
+}
+
+type Event_Notice struct {
+	Domain    string
+	Type_Name string
+	Event     *Event
+}
+
+// Channels receiving appended events by listener ids.
+var listeners = map[int]chan *Event_Notice{}
+var listeners_next_id = 0
+var listeners_lock sync.Mutex
+
+func listen() (int, chan *Event_Notice) {
+	listeners_lock.Lock()
+	defer listeners_lock.Unlock()
+	id := listeners_next_id
+	listeners_next_id++
+	listeners[id] = make(chan *Event_Notice, 256)
+	return id, listeners[id]
+}
+
+func unlisten(id int) {
+	listeners_lock.Lock()
+	defer listeners_lock.Unlock()
+	delete(listeners, id)
+}
+
+// Slow listeners miss events rather than block appending.
+func notify(domain string, type_name string, event *Event) {
+	listeners_lock.Lock()
+	defer listeners_lock.Unlock()
+	notice := &Event_Notice{Domain: domain, Type_Name: type_name, Event: event}
+	for _, ch := range listeners {
+		select {
+		case ch <- notice:
+		default:
+		}
+	}
+}
+
+// Parses `key=value` tokens following the event type.
+func parse_pairs(parts []string) (map[string]string, error) {
+	fields := map[string]string{}
+	for _, part := range parts {
+		key, value, ok := shell.Split_Pair(part)
+		if !ok {
+			return nil, ERR_INVALID_PAIR.With("value", part)
+		}
+		fields[key] = value
+	}
+	return fields, nil
+}
+
+func deprecate_signature(domain string, type_name string) error {
+	_, signature := find_signature(domain, type_name)
+	if signature == nil {
+		return ERR_SIGNATURE_NOT_FOUND.With("type", type_name)
+	}
+	signature.Deprecated = true
+	save_state()
+	return nil
+}
+
+// Deletes signature which has no events, otherwise it can only be deprecated.
+func delete_signature(domain string, type_name string) error {
+	target_type, signature := find_signature(domain, type_name)
+	if signature == nil {
+		return ERR_SIGNATURE_NOT_FOUND.With("type", type_name)
+	}
+	if event_counts[domain][target_type] > 0 {
+		return ERR_SIGNATURE_HAS_EVENTS.With("type", signature.Type_Name)
+	}
+	signature.Deleted = true
+	signature.Fields = map[string]string{}
+	save_state()
+	return nil
+}
+
+// Removes domain with all of it's events, archives, snapshots and
+// checkpoints.
+func delete_domain(domain string) error {
+	if domain == "main" {
+		return ERR_DOMAIN_MAIN.With("action", "deleted")
+	}
+	_, ok := signatures[domain]
+	if !ok {
+		return ERR_DOMAIN_NOT_FOUND.With("domain", domain)
+	}
+
+	f, ok := sigfiles[domain]
+	if ok {
+		f.Close()
+		delete(sigfiles, domain)
+	}
+	delete(signatures, domain)
+	delete(events, domain)
+	delete(segment_indexes, domain)
+	project_drop(domain)
+
+	paths := []string{
+		bone.Userdir("signatures", domain+".json"),
+		segment_dir(domain),
+		segment_archive_dir(domain),
+		bone.Userdir("archive", "retention", domain),
+		snapshot_dir(domain),
+		chain_checkpoints_path(domain),
+	}
+	for _, path := range paths {
+		er := os.RemoveAll(path)
+		if er != nil {
+			return ERR_STORAGE.With("action", "remove", "path", path).Wrap(er)
+		}
+	}
+	return nil
+}
+
+// Parses point in history as `#N` or `N` for a sequence, or as a time like
+// `yesterday`, see `bone.Parse_Time`, returning sequence of the last event at
+// or before it.
+func parse_as_of(domain string, as_of string) (int, error) {
+	seq, er := strconv.Atoi(strings.TrimPrefix(as_of, "#"))
+	if er == nil {
+		return seq, nil
+	}
+	t, er := bone.Parse_Time(as_of)
+	if er != nil {
+		return 0, ERR_INVALID_HISTORY_POINT.With("value", as_of)
+	}
+	seq = 0
+	er = events_reverse(domain, 0, t.UnixMilli()+1, func(event *Event) bool {
+		if event.Created_Ms <= t.UnixMilli() {
+			seq = event.Seq
+			return false
+		}
+		return true
+	})
+	if er != nil {
+		return 0, er
+	}
+	return seq, nil
+}
+
+// Parses a time range like `2026-01..2026-03` or `-2h..`, see
+// `bone.Parse_Time_Range`, into milliseconds. Zero stands for an open end.
+func parse_time_range(text string) (int64, int64, error) {
+	if text == "" {
+		return 0, 0, nil
+	}
+	from, to, er := bone.Parse_Time_Range(text)
+	if er != nil {
+		return 0, 0, ERR_INVALID_TIME.With("value", text, "reason", er)
+	}
+	var from_ms, to_ms int64
+	if !from.IsZero() {
+		from_ms = from.UnixMilli()
+	}
+	if !to.IsZero() {
+		to_ms = to.UnixMilli()
+	}
+	return from_ms, to_ms, nil
+}
+
+// Copies signatures and events of a domain into a new one. Non-empty
+// `as_of` limits copied history, see `parse_as_of`.
+func fork_domain(src string, dst string, as_of string) error {
+	_, ok := signatures[src]
+	if !ok {
+		return ERR_DOMAIN_NOT_FOUND.With("domain", src)
+	}
+	to_seq := 0
+	var er error
+	if as_of != "" {
+		to_seq, er = parse_as_of(src, as_of)
+		if er != nil {
+			return er
+		}
+	}
+	// Events keep their sequences and hashes, so the copy verifies as the
+	// same chain
+	src_evs := []*Event{}
+	if as_of == "" || to_seq > 0 {
+		src_evs, er = events_range(src, 1, to_seq)
+		if er != nil {
+			return er
+		}
+	}
+	er = create_domain(dst)
+	if er != nil {
+		return er
+	}
+
+	for _, signature := range signatures[src] {
+		copied := *signature
+		copied.Fields = map[string]string{}
+		for k, v := range signature.Fields {
+			copied.Fields[k] = v
+		}
+		if signature.Defaults != nil {
+			copied.Defaults = map[string]string{}
+			for k, v := range signature.Defaults {
+				copied.Defaults[k] = v
+			}
+		}
+		signatures[dst] = append(signatures[dst], &copied)
+	}
+
+	evs := []*Event{}
+	for _, event := range src_evs {
+		copied := *event
+		copied.Fields = map[string]string{}
+		for k, v := range event.Fields {
+			copied.Fields[k] = v
+		}
+		evs = append(evs, &copied)
+	}
+	events[dst] = evs
+	segment_indexes[dst] = &Segment_Index{Base_Hash: chain_base(src), Format: segment_format}
+	er = segment_rewrite(dst)
+	if er != nil {
+		return er
+	}
+	project_reset(dst)
+	er = project_catch_up(dst)
+	if er != nil {
+		return er
+	}
+	save_state()
+	return nil
+}
+
+// Renames a domain with all of it's files. Checkpoints are signed again,
+// since their signatures include the domain name. Files are moved back if
+// any of the steps fails.
+func rename_domain(old string, new string) error {
+	if old == "main" {
+		return ERR_DOMAIN_MAIN.With("action", "renamed")
+	}
+	_, ok := signatures[old]
+	if !ok {
+		return ERR_DOMAIN_NOT_FOUND.With("domain", old)
+	}
+	if !shell.Is_Valid_Domain(new) || new == "" {
+		return ERR_DOMAIN_INVALID.With("domain", new)
+	}
+	_, ok = signatures[new]
+	if ok {
+		return ERR_DOMAIN_EXISTS.With("domain", new)
+	}
+
+	f, ok := sigfiles[old]
+	if ok {
+		f.Close()
+		delete(sigfiles, old)
+	}
+	moves := [][2]string{
+		{bone.Userdir("signatures", old+".json"), bone.Userdir("signatures", new+".json")},
+		{segment_dir(old), segment_dir(new)},
+		{segment_archive_dir(old), segment_archive_dir(new)},
+		{bone.Userdir("archive", "retention", old), bone.Userdir("archive", "retention", new)},
+		{snapshot_dir(old), snapshot_dir(new)},
+	}
+	moved := [][2]string{}
+	for _, move := range moves {
+		_, er := os.Stat(move[0])
+		if os.IsNotExist(er) {
+			continue
+		}
+		bone.Mkdir(filepath.Dir(move[1]))
+		er = os.Rename(move[0], move[1])
+		if er != nil {
+			rename_rollback(moved)
+			return ERR_STORAGE.With("action", "move", "path", move[0]).Wrap(er)
+		}
+		moved = append(moved, move)
+	}
+	// Checkpoints are moved last, so they are never signed for a name the
+	// domain failed to get
+	er := chain_resign(old, new)
+	if er != nil {
+		os.Remove(chain_checkpoints_path(new))
+		rename_rollback(moved)
+		return ERR_CHECKPOINTS_NOT_SIGNED.With("domain", new).Wrap(er)
+	}
+
+	signatures[new] = signatures[old]
+	events[new] = events[old]
+	segment_indexes[new] = segment_indexes[old]
+	delete(signatures, old)
+	delete(events, old)
+	delete(segment_indexes, old)
+	project_drop(old)
+	project_reset(new)
+	er = project_catch_up(new)
+	if er != nil {
+		return er
+	}
+	save_state()
+	return nil
+}
+
+// Moves files of a domain failed to be renamed back, in the reverse order.
+func rename_rollback(moved [][2]string) {
+	for i := len(moved) - 1; i >= 0; i-- {
+		er := os.Rename(moved[i][1], moved[i][0])
+		if er != nil {
+			bone.Log_Error("Cannot move '%s' back to '%s', error: %s", moved[i][1], moved[i][0], er)
+		}
+	}
+}
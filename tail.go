@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"time"
+)
+
+// Seconds the server holds a poll of remote tail.
+const tail_poll_sec = 20
+
+func tail_format(type_name string, event *Event) string {
+	return fmt.Sprintf("#%d  %s  %s  %s", event.Seq, bone.Date_Ms(event.Created_Ms, EVENT_DATE_FORMAT), type_name, format_fields(event.Fields))
+}
+
+// Returns up to `n` newest events matching the filter, in the order of their
+// history.
+func tail_recent(sigs []*Event_Signature, evs []*Event, filter *Event_Filter, n int) []*Event {
+	selected := []*Event{}
+	for i := len(evs) - 1; i >= 0 && len(selected) < n; i-- {
+		if filter.Match(type_name_of(sigs, evs[i]), evs[i]) {
+			selected = append([]*Event{evs[i]}, selected...)
+		}
+	}
+	return selected
+}
+
+func shell_tail(c *shell.Command_Context) int {
+	// Following blocks until interrupted, which would hold the state lock of
+	// the server
+	if shell.Is_Captured() {
+		c.Error("Command 'tail' cannot be executed over RPC, use Sevent/WaitEvents instead")
+		return shell.ERROR
+	}
+	filter, er := parse_filter(c.Arg_String("-type", ""), c.Arg_List("-where"))
+	if er != nil {
+		return c.Fail(er)
+	}
+	domain := shell.Get_Domain()
+	n := c.Arg_Int("-n", 10)
+	if remote_url != "" {
+		return remote_tail(c, domain, filter, n)
+	}
+
+	sigs := signatures[domain]
+	recent := []*Event{}
+	er = events_reverse(domain, 0, 0, func(event *Event) bool {
+		if len(recent) >= n {
+			return false
+		}
+		if filter.Match(type_name_of(sigs, event), event) {
+			recent = append(recent, event)
+		}
+		return true
+	})
+	if er != nil {
+		return c.Fail(er)
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		c.Message("%s", tail_format(type_name_of(sigs, recent[i]), recent[i]))
+	}
+
+	id, ch := listen()
+	tail_started(c)
+	shell.Go(func(stop <-chan struct{}) {
+		defer unlisten(id)
+		for {
+			select {
+			case <-stop:
+				return
+			case notice := <-ch:
+				if notice.Domain == domain && filter.Match(notice.Type_Name, notice.Event) {
+					shell.Print_Async("%s", tail_format(notice.Type_Name, notice.Event))
+				}
+			}
+		}
+	})
+	return shell.OK
+}
+
+func tail_started(c *shell.Command_Context) {
+	if shell.Is_Interactive() {
+		c.Message("Following new events, Ctrl-C on an empty line stops")
+	}
+}
+
+func remote_tail(c *shell.Command_Context, domain string, filter *Event_Filter, n int) int {
+	sigs, er := remote_signatures(domain)
+	if er != nil {
+		return c.Fail(er)
+	}
+	// Predicates are checked here, so all events of the type are fetched
+	limit := n
+	if len(filter.Predicates) > 0 {
+		limit = 0
+	}
+	var evs []*Event
+	er = remote_call("Sevent/GetEvents", &Rpc_Get_Events_Args{Domain: domain, EventType: filter.Type_Name, Limit: limit}, &evs)
+	if er != nil {
+		return c.Fail(er)
+	}
+	for _, event := range tail_recent(sigs, evs, filter, n) {
+		c.Message("%s", tail_format(type_name_of(sigs, event), event))
+	}
+	var last []*Event
+	er = remote_call("Sevent/GetEvents", &Rpc_Get_Events_Args{Domain: domain, Limit: 1}, &last)
+	if er != nil {
+		return c.Fail(er)
+	}
+	after := 0
+	if len(last) > 0 {
+		after = last[0].Seq
+	}
+
+	tail_started(c)
+	shell.Go(func(stop <-chan struct{}) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stop
+			cancel()
+		}()
+
+		for ctx.Err() == nil {
+			var evs []*Event
+			er := remote_request(ctx, "Sevent/WaitEvents", &Rpc_Wait_Events_Args{Domain: domain, After: after, Timeout_Sec: tail_poll_sec}, &evs)
+			if ctx.Err() != nil {
+				return
+			}
+			if er != nil {
+				shell.Print_Async("Tail of domain '%s' failed, retrying: %s", domain, er)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+				continue
+			}
+			for _, event := range evs {
+				after = event.Seq
+				if event.Type > len(sigs) {
+					// Signature was added after the tail started
+					fresh, er := remote_signatures_ctx(ctx, domain)
+					if er == nil {
+						sigs = fresh
+					}
+				}
+				type_name := type_name_of(sigs, event)
+				if filter.Match(type_name, event) {
+					shell.Print_Async("%s", tail_format(type_name, event))
+				}
+			}
+		}
+	})
+	return shell.OK
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"seva/lib/bone"
+	"seva/lib/shell"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Templates shipped with the binary, the ones saved under the user directory
+// take precedence.
+//
+//go:embed templates/*.json
+var embedded_templates embed.FS
+
+// Signatures a new domain starts with.
+type Domain_Template struct {
+	Name       string             `json:"name"`
+	Signatures []*Event_Signature `json:"signatures"`
+	// Names of projections the domain relies on, checked to be present in
+	// the binary when a domain is created.
+	Projections []string `json:"projections,omitempty"`
+	// Set when the template is listed, embedded ones are not stored in the
+	// user directory.
+	Embedded bool `json:"-"`
+}
+
+func template_path(name string) string {
+	return bone.Userdir("templates", name+".json")
+}
+
+func parse_template(name string, data []byte) (*Domain_Template, error) {
+	var t Domain_Template
+	er := json.Unmarshal(data, &t)
+	if er != nil {
+		return nil, ERR_TEMPLATE_INVALID.With("name", name).Wrap(er)
+	}
+	t.Name = name
+	return &t, nil
+}
+
+func load_template(name string) (*Domain_Template, error) {
+	// Name becomes a part of the path
+	if !shell.Is_Valid_Domain(name) || name == "" {
+		return nil, ERR_TEMPLATE_NAME.With("name", name)
+	}
+	data, er := os.ReadFile(template_path(name))
+	if er == nil {
+		return parse_template(name, data)
+	}
+	data, er = embedded_templates.ReadFile("templates/" + name + ".json")
+	if er != nil {
+		return nil, ERR_TEMPLATE_NOT_FOUND.With("name", name)
+	}
+	t, er := parse_template(name, data)
+	if er == nil {
+		t.Embedded = true
+	}
+	return t, er
+}
+
+// Lists embedded and saved templates by name.
+func list_templates() []*Domain_Template {
+	names := map[string]bool{}
+	entries, _ := embedded_templates.ReadDir("templates")
+	for _, entry := range entries {
+		names[strings.TrimSuffix(entry.Name(), ".json")] = true
+	}
+	files, _ := filepath.Glob(bone.Userdir("templates", "*.json"))
+	for _, path := range files {
+		names[strings.TrimSuffix(filepath.Base(path), ".json")] = true
+	}
+
+	r := []*Domain_Template{}
+	for name := range names {
+		t, er := load_template(name)
+		if er != nil {
+			bone.Log_Error("%s", er)
+			continue
+		}
+		r = append(r, t)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+// Captures signatures of a domain, deleted and deprecated ones are left out.
+func save_template(name string, domain string) (*Domain_Template, error) {
+	if !shell.Is_Valid_Domain(name) || name == "" {
+		return nil, ERR_TEMPLATE_NAME.With("name", name)
+	}
+	sigs, ok := signatures[domain]
+	if !ok {
+		return nil, ERR_DOMAIN_NOT_FOUND.With("domain", domain)
+	}
+	t := &Domain_Template{Name: name, Signatures: []*Event_Signature{}}
+	for _, sig := range sigs {
+		if !sig.Deleted && !sig.Deprecated {
+			t.Signatures = append(t.Signatures, sig)
+		}
+	}
+	for _, p := range projections {
+		if p.Used != nil && p.Used(domain) {
+			t.Projections = append(t.Projections, p.Name)
+		}
+	}
+
+	data, er := json.MarshalIndent(t, "", "\t")
+	if er != nil {
+		return nil, ERR_ENCODE.With("what", "template "+name).Wrap(er)
+	}
+	bone.Mkdir(bone.Userdir("templates"))
+	er = os.WriteFile(template_path(name), data, 0644)
+	if er != nil {
+		return nil, ERR_STORAGE.With("action", "write", "path", template_path(name)).Wrap(er)
+	}
+	return t, nil
+}
+
+// Creates a domain with signatures of the template.
+func create_domain_from(domain string, name string) error {
+	t, er := load_template(name)
+	if er != nil {
+		return er
+	}
+	for _, p := range t.Projections {
+		known := slices.ContainsFunc(projections, func(known *Projection) bool {
+			return known.Name == p
+		})
+		if !known {
+			return ERR_TEMPLATE_PROJECTION.With("name", name, "projection", p)
+		}
+	}
+	er = create_domain(domain)
+	if er != nil {
+		return er
+	}
+	for _, sig := range t.Signatures {
+		fields := map[string]string{}
+		for key, field_type := range sig.Fields {
+			fields[key] = field_type
+			default_, ok := sig.Defaults[key]
+			if ok {
+				fields[key] += ":" + default_
+			}
+		}
+		_, er = add_signature(domain, sig.Type_Name, fields)
+		if er != nil {
+			delete_domain(domain)
+			return ERR_TEMPLATE_INVALID.With("name", name).Wrap(er)
+		}
+	}
+	return nil
+}
+
+func do_save_template(name string, domain string) (*Domain_Template, error) {
+	if remote_url != "" {
+		var t Domain_Template
+		er := remote_request(context.Background(), "Templates/SaveTemplate", &Rpc_Save_Template_Args{Name: name, Domain: domain}, &t)
+		return &t, er
+	}
+	return save_template(name, domain)
+}
+
+func do_list_templates() ([]*Domain_Template, error) {
+	if remote_url != "" {
+		var r []*Domain_Template
+		er := remote_request(context.Background(), "Templates/GetTemplates", &struct{}{}, &r)
+		return r, er
+	}
+	return list_templates(), nil
+}
+
+func shell_template(c *shell.Command_Context) int {
+	parts := c.Arg_List("_")
+	switch {
+	case parts[0] == "list" && len(parts) == 1:
+		ts, er := do_list_templates()
+		if er != nil {
+			return c.Fail(er)
+		}
+		rows := [][]string{}
+		for _, t := range ts {
+			types := []string{}
+			for _, sig := range t.Signatures {
+				types = append(types, sig.Type_Name)
+			}
+			rows = append(rows, []string{t.Name, strings.Join(types, " "), strings.Join(t.Projections, " ")})
+		}
+		c.Table([]string{"TEMPLATE", "SIGNATURES", "PROJECTIONS"}, rows)
+		return shell.OK
+	case parts[0] == "save" && len(parts) == 2:
+		name := parts[1]
+		save := func() int {
+			t, er := do_save_template(name, shell.Get_Domain())
+			if er != nil {
+				return c.Fail(er)
+			}
+			c.Message_Plural(len(t.Signatures), "Template '%s' saved with %d signatures", name, len(t.Signatures))
+			return shell.OK
+		}
+		ts, er := do_list_templates()
+		if er != nil {
+			return c.Fail(er)
+		}
+		for _, t := range ts {
+			if t.Name == name {
+				return c.Confirm(fmt.Sprintf("Overwrite template '%s'?", name), save)
+			}
+		}
+		return save()
+	}
+	c.Error("Expected `template save NAME` or `template list`")
+	return shell.ERROR
+}
+
+func complete_template(tokens []string) []shell.Completion {
+	if len(tokens) == 1 {
+		return []shell.Completion{
+			{Value: "save", Hint: "Saves signatures of the current domain as a template."},
+			{Value: "list", Hint: "Lists templates."},
+		}
+	}
+	return nil
+}
+
+// Template names for `domain create -template`.
+func complete_template_names() []shell.Completion {
+	r := []shell.Completion{}
+	for _, t := range list_templates() {
+		r = append(r, shell.Completion{Value: t.Name})
+	}
+	return r
+}
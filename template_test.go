@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"seva/lib/bone"
+	"testing"
+)
+
+// Deletes the domain and the template left by a previous test.
+func template_test_cleanup() {
+	_, ok := signatures["template_created"]
+	if ok {
+		bone.Assert(delete_domain("template_created") == nil)
+	}
+	os.Remove(template_path("template_saved"))
+}
+
+func Test_save_template_ok(t *testing.T) {
+	template_test_cleanup()
+	defer template_test_cleanup()
+	test_notes("template_test", "first note")
+	_, er := add_signature("template_test", "TASK", map[string]string{"title": "string"})
+	bone.Assert(er == nil)
+	bone.Assert(deprecate_signature("template_test", "TASK") == nil)
+
+	// Deprecated signatures are left out
+	saved, er := save_template("template_saved", "template_test")
+	bone.Assert(er == nil)
+	bone.Assert(len(saved.Signatures) == 1 && saved.Signatures[0].Type_Name == "NOTE")
+	bone.Assert(len(saved.Projections) == 1 && saved.Projections[0] == "search")
+
+	loaded, er := load_template("template_saved")
+	bone.Assert(er == nil && !loaded.Embedded)
+	bone.Assert(loaded.Name == "template_saved" && loaded.Signatures[0].Fields["text"] == "string")
+	bone.Assert(loaded.Projections[0] == "search")
+
+	listed := map[string]bool{}
+	for _, t := range list_templates() {
+		listed[t.Name] = t.Embedded
+	}
+	embedded, ok := listed["orders"]
+	bone.Assert(ok && embedded)
+	embedded, ok = listed["template_saved"]
+	bone.Assert(ok && !embedded)
+
+	_, er = save_template("../template", "template_test")
+	bone.Assert(errors.Is(er, ERR_TEMPLATE_NAME))
+	_, er = save_template("template_saved", "template_missing")
+	bone.Assert(errors.Is(er, ERR_DOMAIN_NOT_FOUND))
+	_, er = load_template("template_missing")
+	bone.Assert(errors.Is(er, ERR_TEMPLATE_NOT_FOUND))
+}
+
+func Test_create_domain_from_ok(t *testing.T) {
+	template_test_cleanup()
+	defer template_test_cleanup()
+
+	bone.Assert(create_domain_from("template_created", "orders") == nil)
+	sigs := signatures["template_created"]
+	bone.Assert(len(sigs) == 3 && sigs[0].Type_Name == "ORDER" && sigs[2].Type_Name == "REFUND")
+	// Defaults are kept
+	default_, ok := sigs[2].Defaults["reason"]
+	bone.Assert(ok && default_ == "")
+	test_event("template_created", "PAYMENT", map[string]string{"amount": "9.5", "method": "card", "order": "1"})
+
+	// Existing domain is kept as it is
+	bone.Assert(errors.Is(create_domain_from("template_created", "orders"), ERR_DOMAIN_EXISTS))
+	bone.Assert(len(signatures["template_created"]) == 3 && event_count("template_created") == 1)
+}
+
+func Test_create_domain_from_error(t *testing.T) {
+	template_test_cleanup()
+	defer template_test_cleanup()
+	bone.Mkdir(bone.Userdir("templates"))
+	write := func(data string) {
+		bone.Assert(os.WriteFile(template_path("template_saved"), []byte(data), 0644) == nil)
+	}
+	created := func() bool {
+		_, ok := signatures["template_created"]
+		_, er := os.Stat(segment_dir("template_created"))
+		return ok || er == nil
+	}
+
+	write(`{"signatures": [`)
+	bone.Assert(errors.Is(create_domain_from("template_created", "template_saved"), ERR_TEMPLATE_INVALID))
+	bone.Assert(!created())
+
+	write(`{"signatures": [], "projections": ["missing"]}`)
+	er := create_domain_from("template_created", "template_saved")
+	bone.Assert(errors.Is(er, ERR_TEMPLATE_PROJECTION))
+	bone.Assert(!created())
+
+	// Domain is deleted once a signature fails
+	write(`{"signatures": [
+		{"type_name": "GOOD", "fields": {"a": "int"}},
+		{"type_name": "BAD", "fields": {"a": "unknown"}}
+	]}`)
+	er = create_domain_from("template_created", "template_saved")
+	bone.Assert(errors.Is(er, ERR_TEMPLATE_INVALID) && errors.Is(er, ERR_FIELD_TYPE_UNKNOWN))
+	bone.Assert(!created())
+	_, er = os.Stat(bone.Userdir("signatures", "template_created.json"))
+	bone.Assert(os.IsNotExist(er))
+}